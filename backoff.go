@@ -0,0 +1,20 @@
+package database
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff returns jittered exponential delay before retry, retry counts from zero.
+// Delay doubles from min with each retry up to max, jitter takes up to half of it, zero min disables delay.
+func Backoff(retry int, min, max time.Duration) time.Duration {
+	if min <= 0 {
+		return 0
+	}
+
+	d := min << uint(retry)
+	if d > max || d <= 0 {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), Backoff(3, 0, time.Second))
+
+	for retry, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		d := Backoff(retry, 10*time.Millisecond, time.Second)
+		assert.True(t, d >= want/2 && d <= want, "retry %d: %s", retry, d)
+	}
+
+	d := Backoff(100, 10*time.Millisecond, time.Second)
+	assert.True(t, d >= time.Second/2 && d <= time.Second, "overflow is bounded by max: %s", d)
+}
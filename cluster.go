@@ -0,0 +1,562 @@
+package database
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+const (
+	// DefaultHealthCheckInterval default interval between replica health checks
+	DefaultHealthCheckInterval = 5 * time.Second
+	// DefaultHealthCheckTimeout default timeout of replica health check
+	DefaultHealthCheckTimeout = time.Second
+)
+
+var lockingClause = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b|\bINTO\b|\bnextval\s*\(|\bsetval\s*\(`)
+
+// Replica is a read-only database node of cluster
+type Replica struct {
+	client    IClient
+	inFlight  int64
+	unhealthy int32
+}
+
+// Client returns replica client
+func (r *Replica) Client() IClient {
+	return r.client
+}
+
+// InFlight returns number of queries currently running on replica
+func (r *Replica) InFlight() int64 {
+	return atomic.LoadInt64(&r.inFlight)
+}
+
+// Healthy returns false if the last health check of replica failed
+func (r *Replica) Healthy() bool {
+	return atomic.LoadInt32(&r.unhealthy) == 0
+}
+
+func (r *Replica) setHealthy(healthy bool) {
+	var v int32
+	if !healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&r.unhealthy, v)
+}
+
+// ReplicaSelector chooses a replica for read-only query from healthy replicas
+type ReplicaSelector interface {
+	Select(replicas []*Replica) *Replica
+}
+
+type roundRobinSelector struct {
+	next uint64
+}
+
+// NewRoundRobinSelector creates selector which uses replicas in turn
+func NewRoundRobinSelector() ReplicaSelector {
+	return &roundRobinSelector{}
+}
+
+// Select ...
+func (s *roundRobinSelector) Select(replicas []*Replica) *Replica {
+	if len(replicas) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return replicas[n%uint64(len(replicas))]
+}
+
+type leastConnectionsSelector struct{}
+
+// NewLeastConnectionsSelector creates selector which uses replica with the least number of running queries
+func NewLeastConnectionsSelector() ReplicaSelector {
+	return leastConnectionsSelector{}
+}
+
+// Select ...
+func (leastConnectionsSelector) Select(replicas []*Replica) *Replica {
+	var selected *Replica
+	for _, r := range replicas {
+		if selected == nil || r.InFlight() < selected.InFlight() {
+			selected = r
+		}
+	}
+	return selected
+}
+
+// ClusterOptions cluster client options
+type ClusterOptions struct {
+	Selector            ReplicaSelector
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout bounds each replica probe, so that hanging replica is marked unhealthy
+	HealthCheckTimeout time.Duration
+}
+
+// NewClusterOptions create cluster options with defaults
+func NewClusterOptions() *ClusterOptions {
+	return &ClusterOptions{
+		Selector:            NewRoundRobinSelector(),
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		HealthCheckTimeout:  DefaultHealthCheckTimeout,
+	}
+}
+
+// WithSelector update options with new replica selector
+func (o *ClusterOptions) WithSelector(selector ReplicaSelector) *ClusterOptions {
+	o.Selector = selector
+	return o
+}
+
+// WithHealthCheckInterval update options with new health check interval, zero disables health checks
+func (o *ClusterOptions) WithHealthCheckInterval(interval time.Duration) *ClusterOptions {
+	o.HealthCheckInterval = interval
+	return o
+}
+
+// WithHealthCheckTimeout update options with new health check timeout, zero disables timeout
+func (o *ClusterOptions) WithHealthCheckTimeout(timeout time.Duration) *ClusterOptions {
+	o.HealthCheckTimeout = timeout
+	return o
+}
+
+// cluster is a state shared by all clients of the same cluster
+type cluster struct {
+	primary   IClient
+	replicas  []*Replica
+	selector  ReplicaSelector
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type clusterClient struct {
+	*cluster
+	ctx     context.Context
+	primary IClient
+	schema  string
+	timeout time.Duration
+	comment *CommentOptions
+	// interceptors are applied to request copies of replica clients, replicas are shared by all clients
+	interceptors []Interceptor
+	// sticky is set for request-scoped clients, after the first write all queries go to primary
+	sticky *int32
+}
+
+// NewClusterClient creates client which sends writes and transactions to primary
+// and read-only queries to a healthy replica chosen by selector
+func NewClusterClient(primary IClient, replicas []IClient, opts *ClusterOptions) IClient {
+	if opts == nil {
+		opts = NewClusterOptions()
+	}
+	if opts.Selector == nil {
+		opts.Selector = NewRoundRobinSelector()
+	}
+
+	c := &cluster{
+		primary:  primary,
+		replicas: make([]*Replica, 0, len(replicas)),
+		selector: opts.Selector,
+		stop:     make(chan struct{}),
+	}
+	for _, r := range replicas {
+		c.replicas = append(c.replicas, &Replica{client: r})
+	}
+
+	if opts.HealthCheckInterval > 0 && len(c.replicas) > 0 {
+		go c.healthCheck(opts.HealthCheckInterval, opts.HealthCheckTimeout)
+	}
+
+	return &clusterClient{cluster: c, primary: primary}
+}
+
+// ConnectCluster connects to primary and replicas
+func ConnectCluster(appName string, primary *pg.Options, replicas []*pg.Options, opts *ClusterOptions) IClient {
+	replicaClients := make([]IClient, 0, len(replicas))
+	for _, cfg := range replicas {
+		replicaClients = append(replicaClients, Connect(appName, cfg))
+	}
+	return NewClusterClient(Connect(appName, primary), replicaClients, opts)
+}
+
+func (c *cluster) healthCheck(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			for _, r := range c.replicas {
+				r.setHealthy(probe(r.client.Db(), timeout) == nil)
+			}
+		}
+	}
+}
+
+// probe checks that db responds within timeout
+func probe(db *pg.DB, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	_, err := db.ExecContext(ctx, "SELECT 1")
+	return err
+}
+
+// Replicas returns cluster replicas
+func (c *clusterClient) Replicas() []*Replica {
+	return c.replicas
+}
+
+// Db ...
+func (c *clusterClient) Db() *pg.DB {
+	return c.primary.Db()
+}
+
+// Tx ...
+func (c *clusterClient) Tx() *pg.Tx {
+	return nil
+}
+
+// TxOptions ...
+func (c *clusterClient) TxOptions() *TxOptions {
+	return nil
+}
+
+// StartTransaction ...
+func (c *clusterClient) StartTransaction() (IClient, error) {
+	return c.StartTransactionWithOptions(nil)
+}
+
+// StartTransactionWithOptions starts transaction on primary
+func (c *clusterClient) StartTransactionWithOptions(opts *TxOptions) (IClient, error) {
+	c.stick()
+	return c.primary.StartTransactionWithOptions(opts)
+}
+
+// Commit ...
+func (c *clusterClient) Commit() error {
+	return ErrNoTransaction
+}
+
+// Rollback ...
+func (c *clusterClient) Rollback() error {
+	return ErrNoTransaction
+}
+
+// WrapWithContext returns request-scoped client, which sticks to primary after the first write
+func (c *clusterClient) WrapWithContext(ctx context.Context) IClient {
+	return &clusterClient{
+		cluster:      c.cluster,
+		ctx:          ctx,
+		primary:      c.primary.WrapWithContext(ctx),
+		schema:       c.schema,
+		timeout:      c.timeout,
+		comment:      c.comment,
+		sticky:       new(int32),
+		interceptors: c.interceptors,
+	}
+}
+
+// WithSchema returns a copy of client bound to schema
+func (c *clusterClient) WithSchema(schema string) IClient {
+	cp := *c
+	cp.schema = schema
+	cp.primary = c.primary.WithSchema(schema)
+	return &cp
+}
+
+// Schema ...
+func (c *clusterClient) Schema() string {
+	return c.schema
+}
+
+// WithQueryTimeout returns a copy of client with default query timeout of primary and replicas
+func (c *clusterClient) WithQueryTimeout(timeout time.Duration) IClient {
+	cp := *c
+	cp.timeout = timeout
+	cp.primary = c.primary.WithQueryTimeout(timeout)
+	return &cp
+}
+
+// QueryTimeout ...
+func (c *clusterClient) QueryTimeout() time.Duration {
+	return c.primary.QueryTimeout()
+}
+
+// WithComment returns a copy of client which appends sqlcommenter comment to statements of primary and replicas
+func (c *clusterClient) WithComment(opts *CommentOptions) IClient {
+	cp := *c
+	cp.comment = opts
+	cp.primary = c.primary.WithComment(opts)
+	return &cp
+}
+
+// Use appends interceptors to the chains of primary and replicas used by the client
+func (c *clusterClient) Use(interceptors ...Interceptor) {
+	c.primary.Use(interceptors...)
+	c.interceptors = append(c.interceptors[:len(c.interceptors):len(c.interceptors)], interceptors...)
+}
+
+// Context ...
+func (c *clusterClient) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return c.primary.Context()
+}
+
+// WithContext ...
+func (c *clusterClient) WithContext(ctx context.Context) IClient {
+	c.ctx = ctx
+	c.primary.WithContext(ctx)
+	return c
+}
+
+// Close closes primary and all replicas
+func (c *clusterClient) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+
+	err := c.primary.Close()
+	for _, r := range c.replicas {
+		if closeErr := r.client.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down primary and all replicas, returns total number of pending operations
+func (c *clusterClient) Shutdown(ctx context.Context) (int, error) {
+	c.closeOnce.Do(func() { close(c.stop) })
+
+	pending, err := c.primary.Shutdown(ctx)
+	for _, r := range c.replicas {
+		n, shutdownErr := r.client.Shutdown(ctx)
+		pending += n
+		if err == nil {
+			err = shutdownErr
+		}
+	}
+	return pending, err
+}
+
+// Model ...
+func (c *clusterClient) Model(model ...interface{}) *orm.Query {
+	return orm.NewQuery(c, model...).Context(c.ctx)
+}
+
+// Select selects model from replica
+func (c *clusterClient) Select(model interface{}) error {
+	r := c.replica()
+	if r == nil {
+		return c.primary.Select(model)
+	}
+	defer c.release(r)
+	return c.replicaClient(r).Select(model)
+}
+
+// Insert ...
+func (c *clusterClient) Insert(model ...interface{}) error {
+	c.stick()
+	return c.primary.Insert(model...)
+}
+
+// Update ...
+func (c *clusterClient) Update(model interface{}) error {
+	c.stick()
+	return c.primary.Update(model)
+}
+
+// Delete ...
+func (c *clusterClient) Delete(model interface{}) error {
+	c.stick()
+	return c.primary.Delete(model)
+}
+
+// ForceDelete ...
+func (c *clusterClient) ForceDelete(model interface{}) error {
+	c.stick()
+	return c.primary.ForceDelete(model)
+}
+
+// Exec ...
+func (c *clusterClient) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
+	c.stick()
+	return c.primary.Exec(query, params...)
+}
+
+// ExecOne ...
+func (c *clusterClient) ExecOne(query interface{}, params ...interface{}) (orm.Result, error) {
+	c.stick()
+	return c.primary.ExecOne(query, params...)
+}
+
+// Query runs read-only query on replica, other queries on primary
+func (c *clusterClient) Query(model, query interface{}, params ...interface{}) (orm.Result, error) {
+	r := c.route(query)
+	if r == nil {
+		return c.primary.Query(model, query, params...)
+	}
+	defer c.release(r)
+	return c.replicaClient(r).Query(model, query, params...)
+}
+
+// QueryOne runs read-only query on replica, other queries on primary
+func (c *clusterClient) QueryOne(model, query interface{}, params ...interface{}) (orm.Result, error) {
+	r := c.route(query)
+	if r == nil {
+		return c.primary.QueryOne(model, query, params...)
+	}
+	defer c.release(r)
+	return c.replicaClient(r).QueryOne(model, query, params...)
+}
+
+// CopyFrom ...
+func (c *clusterClient) CopyFrom(r io.Reader, query interface{}, params ...interface{}) (orm.Result, error) {
+	c.stick()
+	return c.primary.CopyFrom(r, query, params...)
+}
+
+// CopyTo ...
+func (c *clusterClient) CopyTo(w io.Writer, query interface{}, params ...interface{}) (orm.Result, error) {
+	return c.primary.CopyTo(w, query, params...)
+}
+
+// FormatQuery ...
+func (c *clusterClient) FormatQuery(b []byte, query string, params ...interface{}) []byte {
+	return c.primary.FormatQuery(b, query, params...)
+}
+
+// ModelContext ...
+func (c *clusterClient) ModelContext(ctx context.Context, model ...interface{}) *orm.Query {
+	return orm.NewQuery(c, model...).Context(ctx)
+}
+
+// ExecContext ...
+func (c *clusterClient) ExecContext(ctx context.Context, query interface{}, params ...interface{}) (pg.Result, error) {
+	c.stick()
+	return ormDB(c.primary).ExecContext(ctx, query, params...)
+}
+
+// ExecOneContext ...
+func (c *clusterClient) ExecOneContext(ctx context.Context, query interface{}, params ...interface{}) (pg.Result, error) {
+	c.stick()
+	return ormDB(c.primary).ExecOneContext(ctx, query, params...)
+}
+
+// QueryContext runs read-only query on replica, other queries on primary
+func (c *clusterClient) QueryContext(ctx context.Context, model, query interface{}, params ...interface{}) (pg.Result, error) {
+	r := c.route(query)
+	if r == nil {
+		return ormDB(c.primary).QueryContext(ctx, model, query, params...)
+	}
+	defer c.release(r)
+	return ormDB(c.replicaClient(r)).QueryContext(ctx, model, query, params...)
+}
+
+// QueryOneContext runs read-only query on replica, other queries on primary
+func (c *clusterClient) QueryOneContext(ctx context.Context, model, query interface{}, params ...interface{}) (pg.Result, error) {
+	r := c.route(query)
+	if r == nil {
+		return ormDB(c.primary).QueryOneContext(ctx, model, query, params...)
+	}
+	defer c.release(r)
+	return ormDB(c.replicaClient(r)).QueryOneContext(ctx, model, query, params...)
+}
+
+// Formatter ...
+func (c *clusterClient) Formatter() orm.QueryFormatter {
+	return ormDB(c.primary).Formatter()
+}
+
+// route returns replica for read-only query or nil if query must be run on primary
+func (c *clusterClient) route(query interface{}) *Replica {
+	if !isReadOnlyQuery(queryTemplate(query)) {
+		c.stick()
+		return nil
+	}
+	return c.replica()
+}
+
+// replica acquires healthy replica or returns nil if request sticks to primary or there are no healthy replicas
+func (c *clusterClient) replica() *Replica {
+	if c.sticky != nil && atomic.LoadInt32(c.sticky) == 1 {
+		return nil
+	}
+
+	healthy := make([]*Replica, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		if r.Healthy() {
+			healthy = append(healthy, r)
+		}
+	}
+
+	r := c.selector.Select(healthy)
+	if r != nil {
+		atomic.AddInt64(&r.inFlight, 1)
+	}
+	return r
+}
+
+// replicaClient returns replica client bound to request context
+func (c *clusterClient) replicaClient(r *Replica) IClient {
+	client := r.client
+	if c.ctx != nil {
+		client = client.WrapWithContext(c.ctx)
+	} else if len(c.interceptors) > 0 {
+		// interceptors are added to a copy of shared replica client
+		client = client.WrapWithContext(client.Context())
+	}
+	if c.schema != "" {
+		client = client.WithSchema(c.schema)
+	}
+	if c.timeout != 0 {
+		client = client.WithQueryTimeout(c.timeout)
+	}
+	if c.comment != nil {
+		client = client.WithComment(c.comment)
+	}
+	if len(c.interceptors) > 0 {
+		client.Use(c.interceptors...)
+	}
+	return client
+}
+
+func (c *clusterClient) release(r *Replica) {
+	atomic.AddInt64(&r.inFlight, -1)
+}
+
+func (c *clusterClient) stick() {
+	if c.sticky != nil {
+		atomic.StoreInt32(c.sticky, 1)
+	}
+}
+
+// ormDB returns client as orm.DB, falling back to underlying pg.DB
+func ormDB(client IClient) orm.DB {
+	if db, ok := client.(orm.DB); ok {
+		return db
+	}
+	return client.Db()
+}
+
+// isReadOnlyQuery returns true for SELECT statements without locking clauses and side effects
+func isReadOnlyQuery(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	if len(query) < 6 || !strings.EqualFold(query[:6], "SELECT") {
+		return false
+	}
+	return !lockingClause.MatchString(query)
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	cases := map[string]bool{
+		"SELECT 1":                                    true,
+		"  select * from agent where id = ?":          true,
+		"(SELECT id FROM a) UNION (SELECT id FROM b)": true,
+		"SELECT * FROM agent FOR UPDATE":              false,
+		"SELECT * FROM agent FOR NO KEY UPDATE":       false,
+		"SELECT * FROM agent for share":               false,
+		"SELECT * INTO agent_copy FROM agent":         false,
+		"SELECT nextval('agent_id_seq')":              false,
+		"INSERT INTO agent (name) VALUES ('a')":       false,
+		"WITH d AS (DELETE FROM agent) SELECT 1":      false,
+		"UPDATE agent SET name = 'select'":            false,
+		"":                                            false,
+	}
+
+	for query, want := range cases {
+		assert.Equal(t, want, isReadOnlyQuery(query), query)
+	}
+}
+
+func TestReplicaSelector(t *testing.T) {
+	replicas := []*Replica{{}, {}, {}}
+
+	t.Run("Round robin", func(t *testing.T) {
+		s := NewRoundRobinSelector()
+		for i := 0; i < 6; i++ {
+			assert.Same(t, replicas[i%3], s.Select(replicas))
+		}
+		assert.Nil(t, s.Select(nil))
+	})
+
+	t.Run("Least connections", func(t *testing.T) {
+		replicas[0].inFlight = 2
+		replicas[1].inFlight = 1
+		replicas[2].inFlight = 3
+
+		s := NewLeastConnectionsSelector()
+		assert.Same(t, replicas[1], s.Select(replicas))
+		assert.Nil(t, s.Select(nil))
+	})
+}
+
+// fakeNode records operations routed to a cluster node
+type fakeNode struct {
+	IClient
+	name  string
+	calls *[]string
+}
+
+func (f *fakeNode) record(op string) { *f.calls = append(*f.calls, f.name+":"+op) }
+
+func (f *fakeNode) WrapWithContext(ctx context.Context) IClient { return f }
+func (f *fakeNode) Select(model interface{}) error              { f.record("select"); return nil }
+func (f *fakeNode) Insert(model ...interface{}) error           { f.record("insert"); return nil }
+func (f *fakeNode) StartTransactionWithOptions(opts *TxOptions) (IClient, error) {
+	f.record("begin")
+	return f, nil
+}
+func (f *fakeNode) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
+	f.record("exec")
+	return nil, nil
+}
+func (f *fakeNode) Query(model, query interface{}, params ...interface{}) (orm.Result, error) {
+	f.record("query")
+	return nil, nil
+}
+
+func TestClusterRouting(t *testing.T) {
+	var calls []string
+	primary := &fakeNode{name: "primary", calls: &calls}
+	replica := &fakeNode{name: "replica", calls: &calls}
+	client := NewClusterClient(primary, []IClient{replica}, NewClusterOptions().WithHealthCheckInterval(0))
+
+	run := func(c IClient, fn func(c IClient)) []string {
+		calls = nil
+		fn(c)
+		return calls
+	}
+
+	t.Run("Reads go to replica", func(t *testing.T) {
+		assert.Equal(t, []string{"replica:query", "replica:select"}, run(client, func(c IClient) {
+			c.Query(nil, "SELECT * FROM agent")
+			c.Select(&struct{}{})
+		}))
+	})
+
+	t.Run("Writes and transactions go to primary", func(t *testing.T) {
+		assert.Equal(t, []string{"primary:exec", "primary:query", "primary:insert", "primary:begin"}, run(client, func(c IClient) {
+			c.Exec("UPDATE agent SET name = 'a'")
+			c.Query(nil, "SELECT * FROM agent FOR UPDATE")
+			c.Insert(&struct{}{})
+			c.StartTransaction()
+		}))
+	})
+
+	t.Run("Shared client does not stick", func(t *testing.T) {
+		assert.Equal(t, []string{"primary:exec", "replica:query"}, run(client, func(c IClient) {
+			c.Exec("DELETE FROM agent")
+			c.Query(nil, "SELECT 1")
+		}))
+	})
+
+	t.Run("Request sticks to primary after write", func(t *testing.T) {
+		req := client.WrapWithContext(context.Background())
+		assert.Equal(t, []string{"replica:query", "primary:exec", "primary:query", "primary:select"}, run(req, func(c IClient) {
+			c.Query(nil, "SELECT 1")
+			c.Exec("DELETE FROM agent")
+			c.Query(nil, "SELECT 1")
+			c.Select(&struct{}{})
+		}))
+
+		other := client.WrapWithContext(context.Background())
+		assert.Equal(t, []string{"replica:query"}, run(other, func(c IClient) {
+			c.Query(nil, "SELECT 1")
+		}))
+	})
+
+	t.Run("Request sticks to primary after transaction", func(t *testing.T) {
+		req := client.WrapWithContext(context.Background())
+		assert.Equal(t, []string{"primary:begin", "primary:query"}, run(req, func(c IClient) {
+			c.StartTransaction()
+			c.Query(nil, "SELECT 1")
+		}))
+	})
+
+	t.Run("Unhealthy replica is skipped", func(t *testing.T) {
+		r := client.(*clusterClient).Replicas()[0]
+		r.setHealthy(false)
+		defer r.setHealthy(true)
+
+		assert.Equal(t, []string{"primary:query"}, run(client, func(c IClient) {
+			c.Query(nil, "SELECT 1")
+		}))
+		assert.Equal(t, int64(0), r.InFlight())
+	})
+}
+
+func TestClusterUse(t *testing.T) {
+	replica := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	client := NewClusterClient(NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"})), []IClient{replica},
+		NewClusterOptions().WithHealthCheckInterval(0))
+	defer client.Close()
+
+	errStop := errors.New("stop")
+	req := client.WrapWithContext(context.Background())
+	req.Use(func(ctx context.Context, op *Operation, next func() (orm.Result, error)) (orm.Result, error) {
+		return nil, errStop
+	})
+
+	_, err := req.Query(nil, "SELECT 1")
+	assert.Equal(t, errStop, err)
+	_, err = req.Exec("DELETE FROM agent")
+	assert.Equal(t, errStop, err)
+
+	assert.Empty(t, replica.(*dbWrapper).interceptors, "shared replica is not changed")
+	_, err = client.WrapWithContext(context.Background()).Query(nil, "SELECT 1")
+	assert.NotEqual(t, errStop, err)
+}
+
+func TestClusterHealthCheckTimeout(t *testing.T) {
+	// replica accepts connections, but never responds
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	replica := NewDbClient(pg.Connect(&pg.Options{Addr: ln.Addr().String()}))
+	client := NewClusterClient(NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"})), []IClient{replica},
+		NewClusterOptions().WithHealthCheckInterval(10*time.Millisecond).WithHealthCheckTimeout(50*time.Millisecond))
+	defer client.Close()
+
+	r := client.(*clusterClient).Replicas()[0]
+	assert.Eventually(t, func() bool { return !r.Healthy() }, time.Second, 10*time.Millisecond)
+}
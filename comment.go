@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-pg/pg/v9/orm"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommentOptions sqlcommenter options, comment carries application name and
+// route, request ID and trace context taken from query context
+type CommentOptions struct {
+	Application string
+}
+
+// NewCommentOptions create comment options with defaults
+func NewCommentOptions() *CommentOptions {
+	return &CommentOptions{}
+}
+
+// WithApplication update options with new application value
+func (o *CommentOptions) WithApplication(application string) *CommentOptions {
+	o.Application = application
+	return o
+}
+
+// WithSQLComment appends sqlcommenter comment to every statement of the client stored in ctx
+func WithSQLComment(opts *CommentOptions) Option {
+	return func(ctx context.Context) context.Context {
+		dbc := optionClient(ctx)
+		if dbc == nil {
+			return ctx
+		}
+		return withOptionClient(ctx, dbc.WithComment(opts))
+	}
+}
+
+// WithComment returns a copy of client which appends sqlcommenter comment to statements, nil disables comments
+func (w *dbWrapper) WithComment(opts *CommentOptions) IClient {
+	cp := *w
+	cp.comment = opts
+	return &cp
+}
+
+// comment returns sqlcommenter comment with tags sorted by key, empty if there are no tags
+func (o *CommentOptions) comment(ctx context.Context) string {
+	tags := make([]string, 0, 4)
+	add := func(key, value string) {
+		if value != "" {
+			tags = append(tags, key+"='"+commentEscape(value)+"'")
+		}
+	}
+
+	add("application", o.Application)
+	add("request_id", RequestIDFromContext(ctx))
+	add("route", RouteFromContext(ctx))
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			add("traceparent", "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-"+sc.TraceFlags().String())
+		}
+	}
+
+	if len(tags) == 0 {
+		return ""
+	}
+	return "/*" + strings.Join(tags, ",") + "*/"
+}
+
+// commentEscape percent-encodes all bytes except unreserved characters,
+// so value can contain neither quotes nor comment terminator nor go-pg placeholders
+func commentEscape(value string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isWordChar(c) && c < 0x80 || c == '-' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
+
+// commented returns query with comment appended
+func (w *dbWrapper) commented(ctx context.Context, query interface{}) interface{} {
+	if w.comment == nil {
+		return query
+	}
+	comment := w.comment.comment(ctx)
+	if comment == "" {
+		return query
+	}
+
+	switch typed := query.(type) {
+	case string:
+		return typed + " " + comment
+	case orm.QueryAppender:
+		return newCommentedQuery(typed, comment)
+	}
+	return query
+}
+
+// commentedQuery appends comment to orm query. go-pg formatter accepts only models as query appenders,
+// so it embeds table model of the query.
+type commentedQuery struct {
+	orm.TableModel
+	query   orm.QueryAppender
+	comment string
+}
+
+func newCommentedQuery(query orm.QueryAppender, comment string) *commentedQuery {
+	q := &commentedQuery{query: query, comment: comment}
+	switch typed := query.(type) {
+	case *orm.Query:
+		q.TableModel = typed.TableModel()
+	case interface{ Query() *orm.Query }:
+		q.TableModel = typed.Query().TableModel()
+	}
+	return q
+}
+
+// AppendQuery ...
+func (q *commentedQuery) AppendQuery(fmter orm.QueryFormatter, b []byte) ([]byte, error) {
+	b, err := q.query.AppendQuery(fmter, b)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, ' ')
+	return append(b, q.comment...), nil
+}
+
+// AppendTemplate returns template of the query without comment
+func (q *commentedQuery) AppendTemplate(b []byte) ([]byte, error) {
+	if t, ok := q.query.(orm.TemplateAppender); ok {
+		return t.AppendTemplate(b)
+	}
+	return q.query.AppendQuery(orm.NewFormatter(), b)
+}
+
+// AppendParam ...
+func (q *commentedQuery) AppendParam(fmter orm.QueryFormatter, b []byte, name string) ([]byte, bool) {
+	if q.TableModel == nil {
+		return b, false
+	}
+	return q.TableModel.AppendParam(fmter, b, name)
+}
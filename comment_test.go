@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type commentAgent struct {
+	ID   int64
+	Name string
+}
+
+func TestComment(t *testing.T) {
+	opts := NewCommentOptions().WithApplication("billing api")
+	assert.Equal(t, "", NewCommentOptions().comment(context.Background()))
+
+	ctx := NewRouteContext(context.Background(), "/pkg.Service/Get")
+	ctx = NewRequestIDContext(ctx, "it's */ ?id")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	assert.Equal(t, "/*application='billing%20api',"+
+		"request_id='it%27s%20%2A%2F%20%3Fid',"+
+		"route='%2Fpkg.Service%2FGet',"+
+		"traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/", opts.comment(ctx))
+}
+
+func TestCommentedQuery(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "localhost:1"})
+	defer db.Close()
+
+	w := NewDbClient(db).WithComment(NewCommentOptions().WithApplication("app")).(*dbWrapper)
+	ctx := context.Background()
+
+	assert.Equal(t, "SELECT ? /*application='app'*/", w.commented(ctx, "SELECT ?"))
+
+	query := w.commented(ctx, orm.NewQuery(db, &commentAgent{}).Where("id = ?", 1))
+	fmter := db.Formatter().(*orm.Formatter).WithModel(query)
+	b, err := query.(orm.QueryAppender).AppendQuery(fmter, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `SELECT "comment_agent"."id", "comment_agent"."name" FROM "comment_agents" AS "comment_agent" WHERE (id = 1) /*application='app'*/`, string(b))
+
+	b, err = query.(orm.TemplateAppender).AppendTemplate(nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "application")
+
+	assert.Equal(t, "SELECT 1", NewDbClient(db).(*dbWrapper).commented(ctx, "SELECT 1"))
+}
@@ -4,30 +4,77 @@ import (
 	"context"
 	"github.com/go-pg/pg/v9"
 	"github.com/go-pg/pg/v9/orm"
+	"github.com/rs/zerolog/log"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
 	"reflect"
 )
 
 var dbKey = "db"
 var dbLoggerKey = "dbLogger"
+var optionTargetKey = "dbOptionTarget"
+
+// DefaultName is a name of database stored by NewContext
+const DefaultName = ""
+
+type namedDBKey string
+
+func clientKey(name string) interface{} {
+	if name == DefaultName {
+		return &dbKey
+	}
+	return namedDBKey(name)
+}
 
 // NewContext returns a new Context that carries db
 func NewContext(ctx context.Context, client IClient, options ...Option) context.Context {
-	ctx = context.WithValue(ctx, &dbKey, client)
+	return NewContextNamed(ctx, DefaultName, client, options...)
+}
+
+// NewContextNamed returns a new Context that carries db registered by name, options are applied to this db
+func NewContextNamed(ctx context.Context, name string, client IClient, options ...Option) context.Context {
+	ctx = context.WithValue(ctx, clientKey(name), client)
+	if len(options) == 0 {
+		return ctx
+	}
+
+	prevTarget := optionTarget(ctx)
+	ctx = context.WithValue(ctx, &optionTargetKey, name)
 	for _, f := range options {
 		ctx = f(ctx)
 	}
-	return ctx
+	return context.WithValue(ctx, &optionTargetKey, prevTarget)
 }
 
 // FromContext returns the DB value stored in ctx
 func FromContext(ctx context.Context) IClient {
-	client, ok := ctx.Value(&dbKey).(IClient)
+	return FromContextNamed(ctx, DefaultName)
+}
+
+// FromContextNamed returns the DB value stored in ctx by name
+func FromContextNamed(ctx context.Context, name string) IClient {
+	client, ok := ctx.Value(clientKey(name)).(IClient)
 	if !ok {
 		return nil
 	}
 	return client.WithContext(ctx)
 }
 
+// optionTarget returns name of database which options are applied to
+func optionTarget(ctx context.Context) string {
+	name, _ := ctx.Value(&optionTargetKey).(string)
+	return name
+}
+
+// optionClient returns client which options are applied to
+func optionClient(ctx context.Context) IClient {
+	return FromContextNamed(ctx, optionTarget(ctx))
+}
+
+// withOptionClient replaces client which options are applied to
+func withOptionClient(ctx context.Context, client IClient) context.Context {
+	return context.WithValue(ctx, clientKey(optionTarget(ctx)), client)
+}
+
 // Connect ...
 func Connect(AppName string, cfg *pg.Options) IClient {
 	if cfg.OnConnect == nil {
@@ -56,20 +103,103 @@ func GetTableName(model interface{}) string {
 
 type transactFunc func(client IClient) error
 
-// PerformTransaction ...
+// PerformTransaction runs fns within transaction, when client is already in transaction savepoint is used
 func PerformTransaction(client IClient, fns ...transactFunc) error {
-	txClient, err := client.StartTransaction()
+	return PerformTransactionWithOptions(client, nil, fns...)
+}
+
+// PerformTransactionWithOptions runs fns within transaction started with opts,
+// nested transaction must not request options which differ from outer transaction
+func PerformTransactionWithOptions(client IClient, opts *TxOptions, fns ...transactFunc) error {
+	ctx := client.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return PerformTransactionContextWithOptions(ctx, client, opts, fns...)
+}
+
+// PerformTransactionContext runs fns within transaction bound to ctx.
+// Transaction is rolled back if any of fns fails or panics or ctx is done.
+func PerformTransactionContext(ctx context.Context, client IClient, fns ...transactFunc) error {
+	return PerformTransactionContextWithOptions(ctx, client, nil, fns...)
+}
+
+// PerformTransactionContextWithOptions runs fns within transaction bound to ctx and started with opts
+func PerformTransactionContextWithOptions(ctx context.Context, client IClient, opts *TxOptions, fns ...transactFunc) error {
+	if client.Tx() != nil {
+		if err := opts.CheckNested(client.TxOptions()); err != nil {
+			return pkgerr.NewBadRequestError(err).WithMessage(err.Error())
+		}
+		return performSavepoint(ctx, client, fns...)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+
+	txClient, err := client.WrapWithContext(ctx).StartTransactionWithOptions(opts)
 	if err != nil {
-		return err
+		return pkgerr.Convert(ctx, err)
 	}
 
-	tx := txClient.Tx()
+	defer func() {
+		if r := recover(); r != nil {
+			txClient.Rollback()
+			panic(r)
+		}
+	}()
+
 	for _, fn := range fns {
+		if err := ctx.Err(); err != nil {
+			return rollback(ctx, txClient.Rollback, pkgerr.Convert(ctx, err))
+		}
 		if err := fn(txClient); err != nil {
-			tx.Rollback()
-			return err
+			return rollback(ctx, txClient.Rollback, err)
 		}
 	}
 
-	return tx.Commit()
+	if err := ctx.Err(); err != nil {
+		return rollback(ctx, txClient.Rollback, pkgerr.Convert(ctx, err))
+	}
+
+	if err := txClient.Commit(); err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+	return nil
+}
+
+func performSavepoint(ctx context.Context, client IClient, fns ...transactFunc) error {
+	sp, err := StartSavepoint(client)
+	if err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			sp.Rollback()
+			panic(r)
+		}
+	}()
+
+	for _, fn := range fns {
+		if err := ctx.Err(); err != nil {
+			return rollback(ctx, sp.Rollback, pkgerr.Convert(ctx, err))
+		}
+		if err := fn(client); err != nil {
+			return rollback(ctx, sp.Rollback, err)
+		}
+	}
+
+	if err := sp.Release(); err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+	return nil
+}
+
+// rollback calls rollbackFn and returns cause, rollback error is logged
+func rollback(ctx context.Context, rollbackFn func() error, cause error) error {
+	if err := rollbackFn(); err != nil {
+		log.Error().Err(pkgerr.Convert(ctx, err)).Msg("failed to rollback transaction")
+	}
+	return cause
 }
@@ -0,0 +1,184 @@
+// +build integration
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerformTransactionContext(t *testing.T) {
+	client := NewDbClient(pg.Connect(&cfg))
+	defer client.Close()
+
+	_, err := client.Exec("CREATE TABLE IF NOT EXISTS tx_test (id BIGSERIAL PRIMARY KEY)")
+	assert.Nil(t, err)
+	defer client.Exec("DROP TABLE tx_test")
+
+	count := func() int {
+		var n int
+		_, err := client.QueryOne(pg.Scan(&n), "SELECT count(*) FROM tx_test")
+		assert.Nil(t, err)
+		return n
+	}
+	insert := func(client IClient) error {
+		_, err := client.Exec("INSERT INTO tx_test (id) VALUES (DEFAULT)")
+		return err
+	}
+
+	t.Run("Panic", func(t *testing.T) {
+		assert.Panics(t, func() {
+			PerformTransactionContext(context.Background(), client, insert, func(client IClient) error {
+				panic("test")
+			})
+		})
+		assert.Equal(t, 0, count())
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := PerformTransactionContext(ctx, client, insert, func(client IClient) error {
+			cancel()
+			return nil
+		}, insert)
+
+		assert.True(t, pkgerr.IsTimeout(err))
+		assert.Equal(t, 0, count())
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		fnErr := errors.New("error")
+		err := PerformTransactionContext(context.Background(), client, insert, func(client IClient) error {
+			return fnErr
+		})
+
+		assert.Equal(t, fnErr, err)
+		assert.Equal(t, 0, count())
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	client := NewDbClient(pg.Connect(&cfg)).WithQueryTimeout(50 * time.Millisecond)
+	defer client.Close()
+
+	sleep := func(client IClient) error {
+		_, err := client.Exec("SELECT pg_sleep(1)")
+		return err
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		err := pkgerr.Convert(context.Background(), sleep(client))
+		assert.True(t, pkgerr.IsTimeout(err))
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		ctx := WithQueryTimeout(context.Background(), time.Second*2)
+		_, err := client.WrapWithContext(ctx).Exec("SELECT pg_sleep(0.1)")
+		assert.Nil(t, err)
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := PerformTransactionContext(context.Background(), client, func(client IClient) error {
+			var timeout string
+			_, err := client.QueryOne(pg.Scan(&timeout), "SHOW statement_timeout")
+			assert.Nil(t, err)
+			assert.Equal(t, "50ms", timeout)
+			return sleep(client)
+		})
+		assert.True(t, pkgerr.IsTimeout(err))
+		assert.Equal(t, pkgerr.CodeQueryCanceled, err.(pkgerr.Error).Code())
+	})
+
+	t.Run("Savepoint rollback", func(t *testing.T) {
+		ctx := WithQueryTimeout(context.Background(), time.Second*2)
+		err := PerformTransactionContext(context.Background(), client, func(client IClient) error {
+			showTimeout := func() string {
+				var timeout string
+				err := client.WithContext(ctx).Model().ColumnExpr("current_setting('statement_timeout')").Select(pg.Scan(&timeout))
+				assert.Nil(t, err)
+				return timeout
+			}
+
+			sp, err := StartSavepoint(client)
+			assert.Nil(t, err)
+			assert.Equal(t, "2s", showTimeout())
+			assert.Nil(t, sp.Rollback())
+			assert.Equal(t, "2s", showTimeout(), "timeout is set again after rollback to savepoint")
+			return nil
+		})
+		assert.Nil(t, err)
+	})
+}
+
+func TestExplainSlowQuery(t *testing.T) {
+	client := NewDbClient(pg.Connect(&cfg))
+	defer client.Close()
+
+	logs := make(chan []byte, 10)
+	opts := NewLoggerOptions().
+		WithSlowThreshold(10 * time.Millisecond).
+		WithLevels(zerolog.Disabled, zerolog.WarnLevel, zerolog.ErrorLevel).
+		WithExplain(NewExplainOptions().WithAnalyzeSampleRate(1))
+	ctx := NewContext(context.Background(), client, WithLoggerOptions(zerolog.New(writerFunc(func(p []byte) {
+		logs <- append([]byte{}, p...)
+	})), opts))
+
+	_, err := FromContext(ctx).Exec("SELECT pg_sleep(0.05)")
+	assert.Nil(t, err)
+
+	select {
+	case line := <-logs:
+		var fields struct {
+			Plan map[string]interface{} `json:"plan"`
+		}
+		assert.Nil(t, json.Unmarshal(line, &fields))
+		assert.Equal(t, "Result", fields.Plan["node"])
+		assert.Equal(t, true, fields.Plan["analyzed"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow query is not logged")
+	}
+}
+
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
+
+func TestCheckHealth(t *testing.T) {
+	client := NewDbClient(pg.Connect(&cfg))
+	defer client.Close()
+
+	health, err := CheckHealth(context.Background(), client)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, health.ServerVersion)
+	assert.False(t, health.InRecovery)
+	assert.True(t, health.Latency > 0)
+	assert.Equal(t, uint32(1), health.PoolStats.TotalConns)
+}
+
+func TestShutdownAfterTxCommit(t *testing.T) {
+	client := NewDbClient(pg.Connect(&cfg))
+
+	tx, err := client.StartTransaction()
+	assert.Nil(t, err)
+	var committed bool
+	OnCommit(NewContext(context.Background(), tx), func() { committed = true })
+	assert.Nil(t, tx.Tx().Commit())
+	assert.True(t, committed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	pending, err := client.Shutdown(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending)
+}
@@ -3,9 +3,22 @@ package errors
 import (
 	"context"
 	"errors"
+	"net"
 	"strings"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/sanches1984/gopkg-pg-orm/redact"
+)
+
+const (
+	// CodeSerializationFailure is SQLSTATE of serialization failure
+	CodeSerializationFailure = "40001"
+	// CodeDeadlockDetected is SQLSTATE of detected deadlock
+	CodeDeadlockDetected = "40P01"
+	// CodeLockNotAvailable is SQLSTATE of lock wait timed out by lock_timeout
+	CodeLockNotAvailable = "55P03"
+	// CodeQueryCanceled is SQLSTATE of query cancelled by statement_timeout or cancel request
+	CodeQueryCanceled = "57014"
 )
 
 const (
@@ -18,10 +31,20 @@ const (
 // Convert ...
 func Convert(ctx context.Context, err error) Error {
 	for {
+		if errTyped, ok := err.(Error); ok {
+			return errTyped
+		}
+
 		if err == pg.ErrNoRows {
 			return NewNotFoundError(err)
 		} else if err == pg.ErrMultiRows {
 			return NewBadRequestError(err)
+		} else if err == context.DeadlineExceeded || err == context.Canceled {
+			return NewTimeoutError(err).WithMessage(err.Error())
+		}
+
+		if errTyped, ok := err.(net.Error); ok && errTyped.Timeout() {
+			return NewTimeoutError(err).WithMessage(err.Error())
 		}
 
 		if errTyped, ok := err.(pg.Error); ok {
@@ -38,13 +61,28 @@ func Convert(ctx context.Context, err error) Error {
 
 func convert(err pg.Error) Error {
 	var result Error
-	message := err.Field(pgMessageField)
+	message := redact.Message(err.Field(pgMessageField))
 
 	if strings.Contains(message, pgDuplicateErr) {
 		result = NewConflictError(err)
+	} else if err.Field(pgCodeField) == CodeQueryCanceled {
+		result = NewTimeoutError(err)
 	} else {
 		result = NewInternalError(err)
 	}
 
 	return result.WithParams(err.Field(pgCodeField), err.Field(pgStatusField)).WithMessage(message)
 }
+
+// IsRetryable returns true if transaction failed with serialization failure or deadlock and can be retried
+func IsRetryable(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch Convert(ctx, err).Code() {
+	case CodeSerializationFailure, CodeDeadlockDetected:
+		return true
+	}
+	return false
+}
@@ -7,11 +7,13 @@ const (
 	NotFound   = "Entity not found"
 	Conflict   = "Entity already exists"
 	BadRequest = "Found too many entities"
+	Timeout    = "Query timed out"
 )
 
 type Error interface {
 	Error() string
 	TypeOf(typ ErrorType) bool
+	Code() string
 	WithParams(code, status string) Error
 	WithMessage(msg string) Error
 	WithTag(tag *Tag) Error
@@ -35,6 +37,10 @@ func (e dbError) TypeOf(typ ErrorType) bool {
 	return e.typ == typ
 }
 
+func (e dbError) Code() string {
+	return e.code
+}
+
 func (e *dbError) WithParams(code, status string) Error {
 	e.code = code
 	e.status = status
@@ -80,6 +86,10 @@ func NewConflictError(err error) Error {
 	return &dbError{typ: Conflict, err: err}
 }
 
+func NewTimeoutError(err error) Error {
+	return &dbError{typ: Timeout, err: err}
+}
+
 func IsInternal(err error) bool {
 	v, ok := err.(Error)
 	if !ok {
@@ -111,3 +121,11 @@ func IsConflict(err error) bool {
 	}
 	return v.TypeOf(Conflict)
 }
+
+func IsTimeout(err error) bool {
+	v, ok := err.(Error)
+	if !ok {
+		return false
+	}
+	return v.TypeOf(Timeout)
+}
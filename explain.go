@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+)
+
+var explainKey = "explain"
+
+// ExplainOptions slow query analyzer options
+type ExplainOptions struct {
+	// AnalyzeSampleRate is a share of explained queries which are run with ANALYZE, from 0 to 1
+	AnalyzeSampleRate float64
+	// Every and Burst limit rate of EXPLAIN queries, explains over the limit are skipped,
+	// at most Burst EXPLAIN queries run concurrently
+	Every   time.Duration
+	Burst   int
+	Timeout time.Duration
+}
+
+// NewExplainOptions create explain options with defaults
+func NewExplainOptions() *ExplainOptions {
+	return &ExplainOptions{
+		Every:   10 * time.Second,
+		Burst:   1,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// WithAnalyzeSampleRate update options with new analyzeSampleRate value
+func (o *ExplainOptions) WithAnalyzeSampleRate(rate float64) *ExplainOptions {
+	o.AnalyzeSampleRate = rate
+	return o
+}
+
+// WithRateLimit update options with rate limit of burst EXPLAIN queries per every duration
+func (o *ExplainOptions) WithRateLimit(every time.Duration, burst int) *ExplainOptions {
+	o.Every = every
+	o.Burst = burst
+	return o
+}
+
+// WithTimeout update options with new timeout value
+func (o *ExplainOptions) WithTimeout(timeout time.Duration) *ExplainOptions {
+	o.Timeout = timeout
+	return o
+}
+
+// PlanSummary is a summary of query plan
+type PlanSummary struct {
+	// NodeType and TotalCost describe the top plan node
+	NodeType  string
+	TotalCost float64
+	// PlanRows is estimated number of rows returned by the top node
+	PlanRows float64
+	// Analyzed is true if plan was captured with ANALYZE, ActualRows and ExecutionTime are set only then
+	Analyzed      bool
+	ActualRows    float64
+	ExecutionTime time.Duration
+	// SeqScans are relations read by sequential scan
+	SeqScans []string
+}
+
+// MarshalZerologObject ...
+func (s *PlanSummary) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("node", s.NodeType).
+		Float64("cost", s.TotalCost).
+		Float64("plan_rows", s.PlanRows).
+		Bool("analyzed", s.Analyzed)
+	if s.Analyzed {
+		e.Float64("actual_rows", s.ActualRows).
+			Float64("execution_ms", float64(s.ExecutionTime.Microseconds())/1000)
+	}
+	if len(s.SeqScans) > 0 {
+		e.Strs("seq_scans", s.SeqScans)
+	}
+}
+
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	TotalCost    float64    `json:"Total Cost"`
+	PlanRows     float64    `json:"Plan Rows"`
+	ActualRows   *float64   `json:"Actual Rows"`
+	ActualLoops  float64    `json:"Actual Loops"`
+	Plans        []planNode `json:"Plans"`
+}
+
+type explainResult struct {
+	Plan          planNode `json:"Plan"`
+	ExecutionTime *float64 `json:"Execution Time"`
+}
+
+// parsePlan summarizes output of EXPLAIN (FORMAT JSON)
+func parsePlan(data []byte) (*PlanSummary, error) {
+	var results []explainResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("empty query plan")
+	}
+
+	plan := results[0].Plan
+	summary := &PlanSummary{
+		NodeType:  plan.NodeType,
+		TotalCost: plan.TotalCost,
+		PlanRows:  plan.PlanRows,
+	}
+	if plan.ActualRows != nil {
+		summary.Analyzed = true
+		summary.ActualRows = *plan.ActualRows * plan.ActualLoops
+	}
+	if ms := results[0].ExecutionTime; ms != nil {
+		summary.ExecutionTime = time.Duration(*ms * float64(time.Millisecond))
+	}
+
+	var walk func(node planNode)
+	walk = func(node planNode) {
+		if node.NodeType == "Seq Scan" {
+			summary.SeqScans = append(summary.SeqScans, node.RelationName)
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	walk(plan)
+	return summary, nil
+}
+
+// explainer captures plans of slow queries in a separate transaction, it is shared by all requests
+// and databases, each EXPLAIN is run on the database of explained query
+type explainer struct {
+	opts    *ExplainOptions
+	limiter *rate.Limiter
+	// running bounds number of concurrent EXPLAIN queries
+	running chan struct{}
+}
+
+func newExplainer(opts *ExplainOptions) *explainer {
+	running := opts.Burst
+	if running < 1 {
+		running = 1
+	}
+	return &explainer{
+		opts:    opts,
+		limiter: rate.NewLimiter(rate.Every(opts.Every), opts.Burst),
+		running: make(chan struct{}, running),
+	}
+}
+
+// allow reports whether query may be explained now, only read-only SELECT queries are explained.
+// If it returns true, explain must be called to free the slot of running EXPLAIN.
+func (e *explainer) allow(query string) bool {
+	if !isReadOnlyQuery(query) {
+		return false
+	}
+
+	select {
+	case e.running <- struct{}{}:
+	default:
+		return false
+	}
+	if !e.limiter.Allow() {
+		<-e.running
+		return false
+	}
+	return true
+}
+
+// explain runs EXPLAIN of formatted query on db with search_path set to schema, sampled queries are run with ANALYZE.
+// Statements are run with explain context, so query hooks can skip them.
+func (e *explainer) explain(db *pg.DB, query, schema string) (*PlanSummary, error) {
+	defer func() { <-e.running }()
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), &explainKey, true), e.opts.Timeout)
+	defer cancel()
+
+	stmt := "EXPLAIN (FORMAT JSON) ?"
+	if e.opts.AnalyzeSampleRate > 0 && rand.Float64() < e.opts.AnalyzeSampleRate {
+		stmt = "EXPLAIN (ANALYZE, FORMAT JSON) ?"
+	}
+
+	// transaction is bound to detached ctx, so rollback reaches the server after timeout
+	tx, err := db.WithContext(detachedContext{ctx}).Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if schema != "" {
+		if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO ?", pg.Ident(schema)); err != nil {
+			return nil, err
+		}
+	}
+
+	var plan string
+	if _, err := tx.QueryOneContext(ctx, pg.Scan(&plan), stmt, pg.Safe(query)); err != nil {
+		return nil, err
+	}
+	return parsePlan([]byte(plan))
+}
+
+// isExplain reports whether query is run by explainer
+func isExplain(ctx context.Context) bool {
+	explain, _ := ctx.Value(&explainKey).(bool)
+	return explain
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestParsePlan(t *testing.T) {
+	plan := `[{
+		"Plan": {
+			"Node Type": "Hash Join", "Total Cost": 35.5, "Plan Rows": 120, "Actual Rows": 3, "Actual Loops": 1,
+			"Plans": [
+				{"Node Type": "Seq Scan", "Relation Name": "agent", "Total Cost": 22, "Plan Rows": 1200},
+				{"Node Type": "Hash", "Total Cost": 10, "Plan Rows": 10, "Plans": [
+					{"Node Type": "Seq Scan", "Relation Name": "team", "Total Cost": 10, "Plan Rows": 10}
+				]}
+			]
+		},
+		"Planning Time": 0.1,
+		"Execution Time": 1.5
+	}]`
+
+	summary, err := parsePlan([]byte(plan))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, &PlanSummary{
+		NodeType:      "Hash Join",
+		TotalCost:     35.5,
+		PlanRows:      120,
+		Analyzed:      true,
+		ActualRows:    3,
+		ExecutionTime: 1500 * time.Microsecond,
+		SeqScans:      []string{"agent", "team"},
+	}, summary)
+
+	summary, err = parsePlan([]byte(`[{"Plan": {"Node Type": "Index Scan", "Total Cost": 8.3, "Plan Rows": 1}}]`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.False(t, summary.Analyzed)
+	assert.Empty(t, summary.SeqScans)
+
+	_, err = parsePlan([]byte(`[]`))
+	assert.NotNil(t, err)
+}
+
+func TestExplainerAllow(t *testing.T) {
+	e := newExplainer(NewExplainOptions().WithRateLimit(time.Hour, 2))
+	assert.False(t, e.allow("UPDATE agent SET name = 'x'"))
+	assert.False(t, e.allow("SELECT * FROM agent FOR UPDATE"))
+	assert.True(t, e.allow("SELECT * FROM agent"))
+	assert.True(t, e.allow("SELECT * FROM agent"))
+	assert.False(t, e.allow("SELECT * FROM agent"), "concurrency is bounded")
+
+	<-e.running
+	assert.False(t, e.allow("SELECT * FROM agent"), "rate is limited")
+	assert.Len(t, e.running, 1)
+}
+
+func TestExplainSkippedByHooks(t *testing.T) {
+	ctx := context.WithValue(context.Background(), &explainKey, true)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracing := newTracingHook(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracerName))
+
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+	m, err := NewMetrics(prometheus.NewRegistry(), client, nil)
+	assert.Nil(t, err)
+
+	for _, query := range []string{"BEGIN", "EXPLAIN (FORMAT JSON) SELECT 1", "ROLLBACK"} {
+		event := &pg.QueryEvent{StartTime: time.Now(), Query: query, DB: &pg.Tx{}}
+		c, _ := tracing.BeforeQuery(ctx, event)
+		tracing.AfterQuery(c, event)
+		m.AfterQuery(c, event)
+	}
+
+	assert.Empty(t, exporter.GetSpans())
+	assert.Equal(t, 0, testutil.CollectAndCount(m, "db_query_duration_seconds", "db_transactions_total"))
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/go-pg/pg/v9/orm"
@@ -12,14 +13,24 @@ import (
 type IClient interface {
 	Db() *pg.DB
 	Tx() *pg.Tx
+	TxOptions() *TxOptions
 
 	StartTransaction() (IClient, error)
+	StartTransactionWithOptions(opts *TxOptions) (IClient, error)
+	Commit() error
+	Rollback() error
 	WrapWithContext(ctx context.Context) IClient
-	SetWrappedQueryProcessor(func(ctx context.Context, processor func() (orm.Result, error), query string, model interface{}) (orm.Result, error))
+	WithSchema(schema string) IClient
+	Schema() string
+	WithQueryTimeout(timeout time.Duration) IClient
+	QueryTimeout() time.Duration
+	WithComment(opts *CommentOptions) IClient
+	Use(interceptors ...Interceptor)
 
 	Context() context.Context
 	WithContext(ctx context.Context) IClient
 	Close() error
+	Shutdown(ctx context.Context) (int, error)
 
 	Model(model ...interface{}) *orm.Query
 	Select(model interface{}) error
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// NormalizeQuery returns query shape: comments are stripped, literals and placeholders are replaced with ?,
+// IN lists and repeated VALUES rows are collapsed, keywords and identifiers are lower-cased, whitespace is collapsed.
+// Formatted query and its template have the same shape.
+func NormalizeQuery(query string) string {
+	tokens := collapseLists(tokenize(query))
+
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && spaceBetween(tokens[i-1], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// Fingerprint returns stable hash of query shape, see NormalizeQuery
+func Fingerprint(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(NormalizeQuery(query)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// EventFingerprint returns fingerprint of query event
+func EventFingerprint(event *pg.QueryEvent) (string, error) {
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		return "", err
+	}
+	return Fingerprint(query), nil
+}
+
+const placeholderToken = "?"
+
+func tokenize(query string) []string {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSpace(c):
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			i = skipTo(query, i+2, "\n")
+		case strings.HasPrefix(query[i:], "/*"):
+			i = skipTo(query, i+2, "*/")
+		case c == '\'':
+			i = skipQuoted(query, i+1, '\'', false)
+			tokens = append(tokens, placeholderToken)
+		case (c == 'E' || c == 'e') && i+1 < len(query) && query[i+1] == '\'':
+			i = skipQuoted(query, i+2, '\'', true)
+			tokens = append(tokens, placeholderToken)
+		case c == '"':
+			end := skipQuoted(query, i+1, '"', false)
+			tokens = append(tokens, query[i:end])
+			i = end
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			i = scan(query, i+1, isDigit)
+			tokens = append(tokens, placeholderToken)
+		case c == '$':
+			end := scan(query, i+1, isWordChar)
+			if end < len(query) && query[end] == '$' {
+				i = skipTo(query, end+1, query[i:end+1])
+				tokens = append(tokens, placeholderToken)
+			} else {
+				tokens = append(tokens, query[i:end])
+				i = end
+			}
+		case c == '?':
+			i = scan(query, i+1, isWordChar)
+			tokens = append(tokens, placeholderToken)
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]):
+			i = scan(query, i+1, isNumberChar)
+			tokens = append(tokens, placeholderToken)
+		case isWordChar(c):
+			end := scan(query, i+1, isWordChar)
+			word := strings.ToLower(query[i:end])
+			if word == "true" || word == "false" {
+				word = placeholderToken
+			}
+			tokens = append(tokens, word)
+			i = end
+		case strings.IndexByte("(),;[].", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			end := scan(query, i+1, isOperatorChar)
+			tokens = append(tokens, query[i:end])
+			i = end
+		}
+	}
+	if n := len(tokens); n > 0 && tokens[n-1] == ";" {
+		tokens = tokens[:n-1]
+	}
+	return tokens
+}
+
+// collapseLists replaces placeholder lists of IN (...) with ... and removes VALUES rows equal to the first one
+func collapseLists(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		result = append(result, tokens[i])
+		switch tokens[i] {
+		case "in":
+			if end := placeholderList(tokens, i+1); end > 0 {
+				result = append(result, "(", "...", ")")
+				i = end
+			}
+		case "values":
+			end := groupEnd(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			row := tokens[i+1 : end+1]
+			result = append(result, row...)
+			i = end
+			for i+1 < len(tokens) && tokens[i+1] == "," {
+				end = groupEnd(tokens, i+2)
+				if end < 0 || !equalTokens(tokens[i+2:end+1], row) {
+					break
+				}
+				i = end
+			}
+		}
+	}
+	return result
+}
+
+// placeholderList returns index of closing parenthesis of list of placeholders started at i, -1 if there is no list
+func placeholderList(tokens []string, i int) int {
+	if i >= len(tokens) || tokens[i] != "(" {
+		return -1
+	}
+	for j := i + 1; j < len(tokens); j += 2 {
+		if tokens[j] != placeholderToken {
+			return -1
+		}
+		if j+1 < len(tokens) && tokens[j+1] == ")" {
+			return j + 1
+		}
+		if j+1 >= len(tokens) || tokens[j+1] != "," {
+			return -1
+		}
+	}
+	return -1
+}
+
+// groupEnd returns index of parenthesis closing group started at i, -1 if there is no group
+func groupEnd(tokens []string, i int) int {
+	if i >= len(tokens) || tokens[i] != "(" {
+		return -1
+	}
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func equalTokens(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func spaceBetween(prev, cur string) bool {
+	switch cur {
+	case ",", ")", "]", ".", "::":
+		return false
+	}
+	switch prev {
+	case "(", "[", ".", "::":
+		return false
+	}
+	return true
+}
+
+// skipTo returns index after terminator found from i, or length of query
+func skipTo(query string, i int, terminator string) int {
+	if end := strings.Index(query[i:], terminator); end >= 0 {
+		return i + end + len(terminator)
+	}
+	return len(query)
+}
+
+// skipQuoted returns index after closing quote, doubled quotes and, if backslash is set, backslash escapes are skipped
+func skipQuoted(query string, i int, quote byte, backslash bool) int {
+	for ; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func scan(query string, i int, fn func(c byte) bool) int {
+	for i < len(query) && fn(query[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNumberChar(c byte) bool {
+	return isDigit(c) || c == '.' || c == 'e' || c == 'E'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isOperatorChar(c byte) bool {
+	return strings.IndexByte("+-*/<>=~!@#%^&|`:", c) >= 0
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    `SELECT "agent"."id", a.name FROM agent AS a WHERE id = 42 AND name = 'O''Brien' -- comment`,
+			expected: `select "agent"."id", a.name from agent as a where id = ? and name = ?`,
+		},
+		{
+			query:    "select *\n  from agent /* hint */ where id IN (1, 2,3) and flag = TRUE;",
+			expected: `select * from agent where id in (...) and flag = ?`,
+		},
+		{
+			query:    `SELECT * FROM agent WHERE id IN (?) AND name = ?name AND price > 1.5e3 AND note = $1`,
+			expected: `select * from agent where id in (...) and name = ? and price > ? and note = ?`,
+		},
+		{
+			query:    `INSERT INTO agent (id, name) VALUES (DEFAULT, 'a'), (DEFAULT, 'b') RETURNING id`,
+			expected: `insert into agent (id, name) values (default, ?) returning id`,
+		},
+		{
+			query:    `SELECT $$a ' b$$, $tag$x$tag$, E'\'', data::jsonb->>'key'`,
+			expected: `select ?, ?, ?, data::jsonb ->> ?`,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, NormalizeQuery(test.query), test.query)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	template := `SELECT * FROM agent WHERE id IN (?) AND name = ?`
+	formatted := `SELECT * FROM agent WHERE id IN (1,2,3) AND name = 'bob'`
+
+	assert.Equal(t, Fingerprint(template), Fingerprint(formatted))
+	assert.Len(t, Fingerprint(template), 16)
+	assert.NotEqual(t, Fingerprint(template), Fingerprint(`SELECT * FROM agent WHERE id = ?`))
+
+	fingerprint, err := EventFingerprint(&pg.QueryEvent{Query: template})
+	assert.Nil(t, err)
+	assert.Equal(t, Fingerprint(formatted), fingerprint)
+}
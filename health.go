@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Health is a database health report
+type Health struct {
+	Latency       time.Duration
+	ServerVersion string
+	InRecovery    bool
+	PoolStats     *pg.PoolStats
+}
+
+// CheckHealth measures round-trip latency and reads server state and connection pool statistics,
+// InRecovery is true when server is a replica
+func CheckHealth(ctx context.Context, client IClient) (*Health, error) {
+	conn := client.Db().WithContext(ctx)
+
+	start := time.Now()
+	if _, err := conn.Exec("SELECT 1"); err != nil {
+		return nil, err
+	}
+	health := &Health{Latency: time.Since(start)}
+
+	_, err := conn.QueryOne(
+		pg.Scan(&health.ServerVersion, &health.InRecovery),
+		"SELECT current_setting('server_version'), pg_is_in_recovery()",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	health.PoolStats = conn.PoolStats()
+	return health, nil
+}
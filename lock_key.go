@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+)
+
+// ErrEmptyLockKey is returned when namespace or key of string-keyed lock is empty
+var ErrEmptyLockKey = errors.New("lock namespace and key must not be empty")
+
+// LockKey is a string key of advisory lock in namespace, e.g. service name.
+// It is hashed into the pair of int4 keys of pg_advisory_lock(int, int),
+// so string-keyed locks never collide with locks by bigint id.
+type LockKey struct {
+	Namespace string
+	Key       string
+}
+
+// NewLockKey creates lock key, ErrEmptyLockKey is returned if namespace or key is empty
+func NewLockKey(namespace, key string) (LockKey, error) {
+	if namespace == "" || key == "" {
+		return LockKey{}, ErrEmptyLockKey
+	}
+	return LockKey{Namespace: namespace, Key: key}, nil
+}
+
+// IDs returns int4 keys of advisory lock: hash of namespace and hash of key
+func (k LockKey) IDs() (int32, int32) {
+	return hash32(k.Namespace), hash32(k.Key)
+}
+
+// String ...
+func (k LockKey) String() string {
+	return k.Namespace + "/" + k.Key
+}
+
+// MarshalZerologObject ...
+func (k LockKey) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("namespace", k.Namespace).Str("key", k.Key)
+}
+
+// HeldLockKeys returns keys of namespace which advisory locks are currently held by any session.
+// Locks are matched by 32-bit hashes of namespace and key, so a key is also reported if other key
+// with the same hash is held, the result must be rechecked by the lock itself if it is critical.
+func HeldLockKeys(ctx context.Context, db *pg.DB, namespace string, keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	byID := make(map[uint32][]string, len(keys))
+	for _, key := range keys {
+		id := uint32(hash32(key))
+		byID[id] = append(byID[id], key)
+	}
+
+	var ids []int64
+	_, err := db.QueryOneContext(ctx, pg.Array(&ids), `SELECT array_agg(DISTINCT objid::bigint) FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND objsubid = 2 AND classid::bigint = ?`, uint32(hash32(namespace)))
+	if err != nil {
+		return nil, err
+	}
+
+	var held []string
+	for _, id := range ids {
+		held = append(held, byID[uint32(id)]...)
+	}
+	return held, nil
+}
+
+func hash32(s string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int32(h.Sum32())
+}
+
+// advisoryLock identifies advisory lock by bigint key or by pair of int4 keys
+type advisoryLock struct {
+	// args are arguments of advisory lock functions
+	args []interface{}
+	// tag is objsubid, classid and objid of the lock in pg_locks
+	tag []interface{}
+}
+
+func bigintLock(id int64) advisoryLock {
+	return advisoryLock{
+		args: []interface{}{id},
+		tag:  []interface{}{1, uint32(id >> 32), uint32(id)},
+	}
+}
+
+func pairLock(key1, key2 int32) advisoryLock {
+	return advisoryLock{
+		args: []interface{}{key1, key2},
+		tag:  []interface{}{2, uint32(key1), uint32(key2)},
+	}
+}
+
+// call returns call of advisory lock function fn with placeholders of lock args
+func (l advisoryLock) call(fn string) string {
+	return fn + "(" + strings.TrimSuffix(strings.Repeat("?, ", len(l.args)), ", ") + ")"
+}
+
+// params returns lock args followed by extra params
+func (l advisoryLock) params(extra ...interface{}) []interface{} {
+	return append(append([]interface{}{}, l.args...), extra...)
+}
+
+// held returns params of heldLockCondition matching the lock in shared or exclusive mode
+func (l advisoryLock) held(shared bool) []interface{} {
+	return append(append([]interface{}{}, l.tag...), lockMode(shared))
+}
+
+// lockFunc returns name of advisory lock function fn in shared or exclusive mode
+func lockFunc(fn string, shared bool) string {
+	if shared {
+		return fn + "_shared"
+	}
+	return fn
+}
+
+// lockMode returns mode of advisory lock in pg_locks
+func lockMode(shared bool) string {
+	if shared {
+		return "ShareLock"
+	}
+	return "ExclusiveLock"
+}
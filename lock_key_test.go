@@ -0,0 +1,51 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockKey(t *testing.T) {
+	_, err := NewLockKey("", "report")
+	assert.Equal(t, ErrEmptyLockKey, err)
+	_, err = NewMutexForKey(nil, "billing", "")
+	assert.Equal(t, ErrEmptyLockKey, err)
+
+	key, err := NewLockKey("billing", "report")
+	assert.Nil(t, err)
+	assert.Equal(t, "billing/report", key.String())
+
+	// keys are stable across processes
+	ns, id := key.IDs()
+	assert.Equal(t, int32(1097859292), ns)
+	assert.Equal(t, hash32("report"), id)
+
+	var buf bytes.Buffer
+	mu, err := NewMutexForKey(nil, "billing", "report")
+	assert.Nil(t, err)
+	logger := zerolog.New(&buf)
+	logger.Info().Object("lock", mu).Send()
+	assert.JSONEq(t, `{"level":"info","lock":{"namespace":"billing","key":"report"}}`, buf.String())
+	assert.Equal(t, "billing/report", mu.String())
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	l := bigintLock(-2)
+	assert.Equal(t, "pg_advisory_lock(?)", l.call("pg_advisory_lock"))
+	assert.Equal(t, []interface{}{int64(-2), "x"}, l.params("x"))
+	assert.Equal(t, []interface{}{1, uint32(0xffffffff), uint32(0xfffffffe)}, l.tag)
+
+	l = pairLock(-1, 7)
+	assert.Equal(t, "pg_advisory_lock(?, ?)", l.call("pg_advisory_lock"))
+	assert.Equal(t, []interface{}{int32(-1), int32(7)}, l.params())
+	assert.Equal(t, []interface{}{2, uint32(0xffffffff), uint32(7)}, l.tag)
+}
+
+func TestLockFunc(t *testing.T) {
+	assert.Equal(t, "pg_advisory_lock", lockFunc("pg_advisory_lock", false))
+	assert.Equal(t, "pg_try_advisory_lock_shared", lockFunc("pg_try_advisory_lock", true))
+	assert.Equal(t, []interface{}{2, uint32(1), uint32(2), "ShareLock"}, pairLock(1, 2).held(true))
+}
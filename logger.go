@@ -2,63 +2,343 @@ package database
 
 import (
 	"context"
-	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/rs/zerolog"
-	"time"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+	"github.com/sanches1984/gopkg-pg-orm/redact"
+	"golang.org/x/time/rate"
 )
 
-const queryStartTime = "StartTime"
+const (
+	// shapeTTL is a minimal lifetime of unused query shape limiter, it is extended to the limiter refill time
+	shapeTTL = 10 * time.Minute
+	// shapeSweepInterval is a minimal interval between sweeps of unused query shape limiters
+	shapeSweepInterval = time.Minute
+)
 
 type IDBLogger interface {
 	pg.QueryHook
+	// Suppressed returns number of events dropped by sampling and rate limit
+	Suppressed() uint64
+}
+
+// LoggerOptions query logger options
+type LoggerOptions struct {
+	// SlowThreshold marks queries running longer as slow, zero disables slow queries detection
+	SlowThreshold time.Duration
+	SuccessLevel  zerolog.Level
+	SlowLevel     zerolog.Level
+	ErrorLevel    zerolog.Level
+	// RequestID extracts request ID from query context
+	RequestID func(ctx context.Context) string
+	// Redactor masks logged query params
+	Redactor *redact.Redactor
+	// Explain enables capturing plans of slow SELECT queries, nil disables it
+	Explain *ExplainOptions
+	// SampleRate is a share of logged events, from 0 to 1
+	SampleRate float64
+	// Every and Burst limit rate of logged events per query fingerprint, zero Every disables the limit
+	Every time.Duration
+	Burst int
+	// AlwaysLogErrors makes failed queries bypass sampling and rate limit
+	AlwaysLogErrors bool
+}
+
+// NewLoggerOptions create logger options with defaults
+func NewLoggerOptions() *LoggerOptions {
+	return &LoggerOptions{
+		SuccessLevel:    zerolog.InfoLevel,
+		SlowLevel:       zerolog.WarnLevel,
+		ErrorLevel:      zerolog.ErrorLevel,
+		RequestID:       RequestIDFromContext,
+		Redactor:        redact.Default,
+		SampleRate:      1,
+		AlwaysLogErrors: true,
+	}
+}
+
+// WithSlowThreshold update options with new slowThreshold value
+func (o *LoggerOptions) WithSlowThreshold(threshold time.Duration) *LoggerOptions {
+	o.SlowThreshold = threshold
+	return o
 }
 
+// WithLevels update options with levels of successful, slow and failed queries,
+// zerolog.Disabled turns logging of the outcome off
+func (o *LoggerOptions) WithLevels(success, slow, err zerolog.Level) *LoggerOptions {
+	o.SuccessLevel = success
+	o.SlowLevel = slow
+	o.ErrorLevel = err
+	return o
+}
+
+// WithRequestID update options with request ID extractor
+func (o *LoggerOptions) WithRequestID(fn func(ctx context.Context) string) *LoggerOptions {
+	o.RequestID = fn
+	return o
+}
+
+// WithRedactor update options with redactor of query params
+func (o *LoggerOptions) WithRedactor(r *redact.Redactor) *LoggerOptions {
+	o.Redactor = r
+	return o
+}
+
+// WithExplain update options with slow query analyzer options.
+// Log event of explained query is written after EXPLAIN finishes and carries plan summary.
+func (o *LoggerOptions) WithExplain(explain *ExplainOptions) *LoggerOptions {
+	o.Explain = explain
+	return o
+}
+
+// WithSampleRate update options with new sampleRate value
+func (o *LoggerOptions) WithSampleRate(rate float64) *LoggerOptions {
+	o.SampleRate = rate
+	return o
+}
+
+// WithRateLimit update options with rate limit of burst events per every duration for each query fingerprint
+func (o *LoggerOptions) WithRateLimit(every time.Duration, burst int) *LoggerOptions {
+	o.Every = every
+	o.Burst = burst
+	return o
+}
+
+// WithAlwaysLogErrors update options with new alwaysLogErrors value
+func (o *LoggerOptions) WithAlwaysLogErrors(always bool) *LoggerOptions {
+	o.AlwaysLogErrors = always
+	return o
+}
+
+// LoggerFromContext returns query logger installed by WithLogger or WithLoggerOptions
+func LoggerFromContext(ctx context.Context) IDBLogger {
+	logger, _ := ctx.Value(&dbLoggerKey).(IDBLogger)
+	return logger
+}
+
+// dbLogger is created once per WithLoggerOptions, so its sampling and rate limit state is shared by all requests
 type dbLogger struct {
-	logger   zerolog.Logger
-	duration time.Duration
+	// suppressed and lastSweep are accessed atomically, they go first to be 64-bit aligned
+	suppressed uint64
+	// lastSweep is unix nano time of the last sweep of shapes
+	lastSweep int64
+	logger    zerolog.Logger
+	opts      *LoggerOptions
+	explainer *explainer
+	// shapes holds *shapeLimiter by query fingerprint
+	shapes sync.Map
 }
 
-func newDBLogger(logger zerolog.Logger, duration time.Duration) IDBLogger {
-	return &dbLogger{
-		logger:   logger,
-		duration: duration,
+// shapeLimiter limits logged events of query fingerprint
+type shapeLimiter struct {
+	suppressed uint64
+	// lastSeen is unix nano time of the last event
+	lastSeen int64
+	limiter  *rate.Limiter
+}
+
+func newDBLogger(logger zerolog.Logger, opts *LoggerOptions) *dbLogger {
+	d := &dbLogger{
+		logger: logger,
+		opts:   opts,
+	}
+	if opts.Explain != nil {
+		d.explainer = newExplainer(opts.Explain)
 	}
+	return d
 }
 
-func (d *dbLogger) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
-	if event.Stash == nil {
-		event.Stash = make(map[interface{}]interface{})
+// dbLoggerHook is a query hook of dbLogger installed to db, slow queries of db and its transactions are explained on db
+type dbLoggerHook struct {
+	*dbLogger
+	db *pg.DB
+}
+
+func (h dbLoggerHook) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	return h.afterQuery(ctx, event, h.db)
+}
+
+// Suppressed ...
+func (d *dbLogger) Suppressed() uint64 {
+	return atomic.LoadUint64(&d.suppressed)
+}
+
+// allow decides whether event of query fingerprint is logged,
+// it returns number of events of the fingerprint suppressed since the last logged one
+func (d *dbLogger) allow(fingerprint string, failed bool) (bool, uint64) {
+	var shape *shapeLimiter
+	if d.opts.Every > 0 {
+		now := time.Now()
+		v, ok := d.shapes.Load(fingerprint)
+		if !ok {
+			d.sweep(now)
+			v, _ = d.shapes.LoadOrStore(fingerprint, &shapeLimiter{limiter: rate.NewLimiter(rate.Every(d.opts.Every), d.opts.Burst)})
+		}
+		shape = v.(*shapeLimiter)
+		atomic.StoreInt64(&shape.lastSeen, now.UnixNano())
+	}
+
+	if !failed || !d.opts.AlwaysLogErrors {
+		if d.opts.SampleRate < 1 && rand.Float64() >= d.opts.SampleRate || shape != nil && !shape.limiter.Allow() {
+			atomic.AddUint64(&d.suppressed, 1)
+			if shape != nil {
+				atomic.AddUint64(&shape.suppressed, 1)
+			}
+			return false, 0
+		}
+	}
+
+	if shape == nil {
+		return true, 0
+	}
+	return true, atomic.SwapUint64(&shape.suppressed, 0)
+}
+
+// sweep drops limiters of query shapes unused longer than shapeTTL, at most once per shapeSweepInterval,
+// so that memory is bounded for unbounded number of query shapes. Limiter is dropped when it is refilled,
+// so new limiter of the shape does not log more.
+func (d *dbLogger) sweep(now time.Time) {
+	last := atomic.LoadInt64(&d.lastSweep)
+	if now.UnixNano()-last < int64(shapeSweepInterval) || !atomic.CompareAndSwapInt64(&d.lastSweep, last, now.UnixNano()) {
+		return
+	}
+
+	ttl := shapeTTL
+	if refill := d.opts.Every * time.Duration(d.opts.Burst); refill > ttl {
+		ttl = refill
 	}
-	event.Stash[queryStartTime] = time.Now()
+	d.shapes.Range(func(fingerprint, v interface{}) bool {
+		if now.UnixNano()-atomic.LoadInt64(&v.(*shapeLimiter).lastSeen) >= int64(ttl) {
+			d.shapes.Delete(fingerprint)
+		}
+		return true
+	})
+}
+
+func (d *dbLogger) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
 	return ctx, nil
 }
 
+// AfterQuery logs query, slow query is explained on db of the event, queries of transaction are not explained
 func (d *dbLogger) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
-	query, err := event.FormattedQuery()
-	if err == nil {
-		var duration time.Duration
-		if event.Stash != nil {
-			if v, ok := event.Stash[queryStartTime]; ok {
-				duration = time.Now().Sub(v.(time.Time))
-			}
-		}
-		logEvent := d.logger.Info()
-		if d.duration != 0 {
-			if d.duration > duration {
-				return nil
-			}
-			logEvent = d.logger.Warn()
+	db, _ := event.DB.(*pg.DB)
+	return d.afterQuery(ctx, event, db)
+}
+
+func (d *dbLogger) afterQuery(ctx context.Context, event *pg.QueryEvent, db *pg.DB) error {
+	if isExplain(ctx) || isImplicitTx(ctx) {
+		return nil
+	}
+
+	duration := time.Since(event.StartTime)
+	failed := event.Err != nil && event.Err != pg.ErrNoRows
+	slow := d.opts.SlowThreshold != 0 && duration >= d.opts.SlowThreshold
+
+	level := d.opts.SuccessLevel
+	if failed {
+		level = d.opts.ErrorLevel
+	} else if slow {
+		level = d.opts.SlowLevel
+	}
+	if level == zerolog.Disabled {
+		return nil
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		return nil
+	}
+
+	fingerprint := Fingerprint(query)
+	ok, suppressed := d.allow(fingerprint, failed)
+	if !ok {
+		return nil
+	}
+
+	logEvent := d.logger.WithLevel(level).
+		Str("query", query).
+		Str("fingerprint", fingerprint).
+		Float64("duration_ms", float64(duration.Microseconds())/1000).
+		Str("operation", string(statementKind(query))).
+		Bool("tx", isTx(event)).
+		Bool("slow", slow)
+	if params := d.opts.Redactor.Params(query, event.Model, queryParams(event)); len(params) > 0 {
+		values := make([]string, 0, len(params))
+		for _, p := range params {
+			values = append(values, p.String())
 		}
-		txt := "query: " + query
-		if duration != 0 {
-			txt += fmt.Sprintf(" [%d ms]", duration.Nanoseconds()/1000000)
+		logEvent = logEvent.Strs("params", values)
+	}
+	if table := operationTable(event.Model, query); table != "" {
+		logEvent = logEvent.Str("table", table)
+	}
+	if suppressed > 0 {
+		logEvent = logEvent.Uint64("suppressed", suppressed)
+	}
+	if event.Result != nil {
+		logEvent = logEvent.
+			Int("rows_affected", event.Result.RowsAffected()).
+			Int("rows_returned", event.Result.RowsReturned())
+	}
+	if d.opts.RequestID != nil {
+		if requestID := d.opts.RequestID(ctx); requestID != "" {
+			logEvent = logEvent.Str("request_id", requestID)
 		}
-		if event.Err != nil {
-			txt += "\nerror: " + event.Err.Error()
+	}
+	if failed {
+		logEvent = logEvent.Str(zerolog.ErrorFieldName, redact.Message(event.Err.Error()))
+		if code := pkgerr.Convert(ctx, event.Err).Code(); code != "" {
+			logEvent = logEvent.Str("sqlstate", code)
 		}
+	}
 
-		logEvent.Msg(txt)
+	if slow && !failed && db != nil && d.explainer != nil && d.explainer.allow(query) {
+		formatted, err := event.FormattedQuery()
+		if err == nil {
+			go d.explain(db, logEvent, formatted, SchemaFromContext(ctx))
+			return nil
+		}
+		// slot of running EXPLAIN is freed by explain only
+		<-d.explainer.running
 	}
+
+	logEvent.Msg("db query")
 	return nil
 }
+
+// explain writes log event with plan summary of query
+func (d *dbLogger) explain(db *pg.DB, logEvent *zerolog.Event, query, schema string) {
+	plan, err := d.explainer.explain(db, query, schema)
+	if err != nil {
+		logEvent = logEvent.Str("explain_error", redact.Message(err.Error()))
+	} else {
+		logEvent = logEvent.Object("plan", plan)
+	}
+	logEvent.Msg("db query")
+}
+
+func isTx(event *pg.QueryEvent) bool {
+	_, ok := event.DB.(*pg.Tx)
+	return ok
+}
+
+// queryParams returns params bound to placeholders of unformatted query, ORM query is logged as template
+// and its only param is the model, trailing table model of string query is not bound to a placeholder
+func queryParams(event *pg.QueryEvent) []interface{} {
+	if _, ok := event.Query.(orm.TemplateAppender); ok {
+		return nil
+	}
+	params := event.Params
+	if n := len(params); n > 0 {
+		if _, ok := params[n-1].(orm.TableModel); ok {
+			params = params[:n-1]
+		}
+	}
+	return params
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBLogger(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "localhost:1"})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	opts := NewLoggerOptions().
+		WithSlowThreshold(time.Second).
+		WithLevels(zerolog.Disabled, zerolog.WarnLevel, zerolog.ErrorLevel)
+	hook := newDBLogger(zerolog.New(&buf), opts)
+
+	query := func(ctx context.Context, startTime time.Time, query string, err error) map[string]interface{} {
+		buf.Reset()
+		event := &pg.QueryEvent{StartTime: startTime, DB: db, Query: query, Params: []interface{}{1}, Err: err}
+		ctx, _ = hook.BeforeQuery(ctx, event)
+		hook.AfterQuery(ctx, event)
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		fields := make(map[string]interface{})
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &fields))
+		return fields
+	}
+
+	ctx := NewRequestIDContext(context.Background(), "req-1")
+
+	assert.Nil(t, query(ctx, time.Now(), "SELECT * FROM agent WHERE id = ?", nil))
+
+	fields := query(ctx, time.Now().Add(-2*time.Second), "SELECT * FROM agent WHERE id = ?", nil)
+	if assert.NotNil(t, fields) {
+		assert.Equal(t, "warn", fields["level"])
+		assert.Equal(t, "SELECT * FROM agent WHERE id = ?", fields["query"])
+		assert.Equal(t, []interface{}{"id=1"}, fields["params"])
+		assert.Equal(t, Fingerprint("SELECT * FROM agent WHERE id = ?"), fields["fingerprint"])
+		assert.Equal(t, "select", fields["operation"])
+		assert.Equal(t, "agent", fields["table"])
+		assert.Equal(t, "req-1", fields["request_id"])
+		assert.Equal(t, false, fields["tx"])
+		assert.Equal(t, true, fields["slow"])
+		assert.True(t, fields["duration_ms"].(float64) >= 2000)
+	}
+
+	fields = query(context.Background(), time.Now(), "UPDATE agent SET password = ?", sqlStateError("23505"))
+	if assert.NotNil(t, fields) {
+		assert.Equal(t, "error", fields["level"])
+		assert.Equal(t, "update", fields["operation"])
+		assert.Equal(t, []interface{}{"password=***"}, fields["params"])
+		assert.Equal(t, "23505", fields["sqlstate"])
+		assert.Equal(t, "23505", fields["error"])
+		assert.NotContains(t, fields, "request_id")
+	}
+}
+
+func TestDBLoggerSuppression(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "localhost:1"})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	opts := NewLoggerOptions().WithRateLimit(time.Hour, 2)
+	hook := newDBLogger(zerolog.New(&buf), opts)
+
+	query := func(query string, err error) map[string]interface{} {
+		buf.Reset()
+		event := &pg.QueryEvent{StartTime: time.Now(), DB: db, Query: query, Err: err}
+		hook.AfterQuery(context.Background(), event)
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		fields := make(map[string]interface{})
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &fields))
+		return fields
+	}
+
+	assert.NotNil(t, query("SELECT 1", nil))
+	assert.NotNil(t, query("SELECT 2", nil))
+	assert.Nil(t, query("SELECT 3", nil))
+	assert.NotNil(t, query("SELECT 1 FROM agent", nil))
+	assert.Equal(t, uint64(1), hook.Suppressed())
+
+	fields := query("SELECT 4", sqlStateError("23505"))
+	if assert.NotNil(t, fields) {
+		assert.Equal(t, float64(1), fields["suppressed"])
+	}
+
+	opts.WithSampleRate(0).WithAlwaysLogErrors(false)
+	assert.Nil(t, query("SELECT 5", sqlStateError("23505")))
+	assert.Equal(t, uint64(2), hook.Suppressed())
+}
+
+func TestDBLoggerShapesSweep(t *testing.T) {
+	d := newDBLogger(zerolog.Nop(), NewLoggerOptions().WithRateLimit(time.Second, 1))
+	shapes := func() int {
+		n := 0
+		d.shapes.Range(func(_, _ interface{}) bool { n++; return true })
+		return n
+	}
+
+	now := time.Now()
+	d.allow("a", false)
+	d.allow("b", false)
+	assert.Equal(t, 2, shapes())
+
+	d.sweep(now.Add(shapeTTL - time.Second))
+	assert.Equal(t, 2, shapes(), "shapes are used recently")
+
+	d.sweep(now.Add(shapeTTL))
+	assert.Equal(t, 2, shapes(), "sweep is run at most once per interval")
+
+	d.sweep(now.Add(shapeTTL + shapeSweepInterval))
+	assert.Equal(t, 0, shapes())
+}
+
+func TestDBLoggerSharedAcrossContexts(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	var buf bytes.Buffer
+	option := WithLoggerOptions(zerolog.New(&buf), NewLoggerOptions().WithRateLimit(time.Hour, 1))
+	ctx1 := NewContext(context.Background(), client.WrapWithContext(context.Background()), option)
+	ctx2 := NewContext(context.Background(), client.WrapWithContext(context.Background()), option)
+
+	hook1, hook2 := LoggerFromContext(ctx1), LoggerFromContext(ctx2)
+	if !assert.NotNil(t, hook1) || !assert.NotNil(t, hook2) {
+		return
+	}
+
+	query := func(hook IDBLogger, ctx context.Context) bool {
+		buf.Reset()
+		hook.AfterQuery(ctx, &pg.QueryEvent{StartTime: time.Now(), Query: "SELECT * FROM agent WHERE id = 1"})
+		return buf.Len() > 0
+	}
+
+	assert.True(t, query(hook1, ctx1))
+	assert.False(t, query(hook2, ctx2), "rate limit of query shape is shared")
+	assert.False(t, query(hook1, ctx1))
+	assert.Equal(t, uint64(2), hook1.Suppressed())
+	assert.Equal(t, uint64(2), hook2.Suppressed())
+}
+
+func TestDBLoggerExplainsOnClientDB(t *testing.T) {
+	logs := make(chan map[string]interface{}, 3)
+	opts := NewLoggerOptions().
+		WithSlowThreshold(time.Millisecond).
+		WithLevels(zerolog.Disabled, zerolog.WarnLevel, zerolog.ErrorLevel).
+		WithExplain(NewExplainOptions().WithRateLimit(time.Millisecond, 2))
+	d := newDBLogger(zerolog.New(logWriter(logs)), opts)
+
+	dbA := pg.Connect(&pg.Options{Addr: "localhost:1"})
+	defer dbA.Close()
+	dbB := pg.Connect(&pg.Options{Addr: "localhost:2"})
+	defer dbB.Close()
+	dbC := pg.Connect(&pg.Options{Addr: "localhost:3"})
+	defer dbC.Close()
+
+	explain := func(hook pg.QueryHook) map[string]interface{} {
+		event := &pg.QueryEvent{StartTime: time.Now().Add(-time.Second), DB: dbC, Query: "SELECT * FROM agent"}
+		hook.AfterQuery(context.Background(), event)
+		select {
+		case fields := <-logs:
+			return fields
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow query is not logged")
+			return nil
+		}
+	}
+
+	assert.Contains(t, explain(dbLoggerHook{dbLogger: d, db: dbA})["explain_error"], "127.0.0.1:1")
+	time.Sleep(2 * time.Millisecond)
+	assert.Contains(t, explain(dbLoggerHook{dbLogger: d, db: dbB})["explain_error"], "127.0.0.1:2")
+	time.Sleep(2 * time.Millisecond)
+	assert.Contains(t, explain(d)["explain_error"], "127.0.0.1:3", "unbound logger explains on db of the event")
+}
+
+// logWriter sends every written JSON log line to logs
+type logWriter chan map[string]interface{}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, err
+	}
+	w <- fields
+	return len(p), nil
+}
+
+func TestDBLoggerORMQuery(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "localhost:1"})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	db.AddQueryHook(newDBLogger(zerolog.New(&buf), NewLoggerOptions().WithLevels(zerolog.InfoLevel, zerolog.InfoLevel, zerolog.ErrorLevel)))
+	client := NewDbClient(db)
+
+	logged := func(fn func() error) map[string]interface{} {
+		buf.Reset()
+		assert.NotNil(t, fn())
+		fields := make(map[string]interface{})
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &fields))
+		return fields
+	}
+
+	fields := logged(func() error { return client.Insert(&secretModel{ID: 1, APIKey: "k3y"}) })
+	assert.Equal(t, "insert", fields["operation"])
+	assert.NotContains(t, fields, "params")
+	assert.NotContains(t, buf.String(), "k3y")
+
+	fields = logged(func() error { return client.Model(&secretModel{}).Where("id = ?", 1).Select() })
+	assert.Equal(t, "select", fields["operation"])
+	assert.NotContains(t, fields, "params")
+
+	fields = logged(func() error {
+		_, err := client.Query(&secretModel{}, "SELECT * FROM account WHERE api_key = ?", "k3y")
+		return err
+	})
+	assert.Equal(t, []interface{}{"api_key=***"}, fields["params"])
+}
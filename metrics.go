@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+)
+
+// MetricLabel is a label of query metrics
+type MetricLabel string
+
+const (
+	// LabelOperation is a statement kind label, e.g. select
+	LabelOperation MetricLabel = "operation"
+	// LabelTable is a table name label
+	LabelTable MetricLabel = "table"
+)
+
+const otherTable = "other"
+
+// MetricsOptions metrics options
+type MetricsOptions struct {
+	Namespace string
+	Buckets   []float64
+	// Labels of query duration histogram
+	Labels []MetricLabel
+	// Tables is an allowlist of table label values, other tables are reported as "other",
+	// so label cardinality is bounded by default
+	Tables []string
+}
+
+// NewMetricsOptions create metrics options with defaults.
+// Tables allowlist is empty by default, so every table is reported as "other" until it is set by WithTables.
+func NewMetricsOptions() *MetricsOptions {
+	return &MetricsOptions{
+		Namespace: "db",
+		Buckets:   prometheus.DefBuckets,
+		Labels:    []MetricLabel{LabelOperation, LabelTable},
+	}
+}
+
+// WithNamespace update options with new namespace value
+func (o *MetricsOptions) WithNamespace(namespace string) *MetricsOptions {
+	o.Namespace = namespace
+	return o
+}
+
+// WithBuckets update options with new histogram buckets
+func (o *MetricsOptions) WithBuckets(buckets []float64) *MetricsOptions {
+	o.Buckets = buckets
+	return o
+}
+
+// WithLabels update options with new set of query labels
+func (o *MetricsOptions) WithLabels(labels ...MetricLabel) *MetricsOptions {
+	o.Labels = labels
+	return o
+}
+
+// WithTables update options with allowlist of tables reported in table label
+func (o *MetricsOptions) WithTables(tables ...string) *MetricsOptions {
+	o.Tables = tables
+	return o
+}
+
+// Metrics is a prometheus collector of query, error, transaction and connection pool metrics
+type Metrics struct {
+	client       IClient
+	labels       []MetricLabel
+	tables       map[string]bool
+	duration     *prometheus.HistogramVec
+	errors       *prometheus.CounterVec
+	transactions *prometheus.CounterVec
+	// replicas are instrumented once, as replica clients are shared by all requests
+	replicas sync.Once
+
+	poolHits       *prometheus.Desc
+	poolMisses     *prometheus.Desc
+	poolTimeouts   *prometheus.Desc
+	poolTotalConns *prometheus.Desc
+	poolIdleConns  *prometheus.Desc
+	poolStaleConns *prometheus.Desc
+}
+
+// NewMetrics creates metrics of client and registers them in reg
+func NewMetrics(reg prometheus.Registerer, client IClient, opts *MetricsOptions) (*Metrics, error) {
+	if opts == nil {
+		opts = NewMetricsOptions()
+	}
+
+	labels := make([]string, 0, len(opts.Labels))
+	for _, l := range opts.Labels {
+		labels = append(labels, string(l))
+	}
+
+	m := &Metrics{
+		client: client,
+		labels: opts.Labels,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of database queries.",
+			Buckets:   opts.Buckets,
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "query_errors_total",
+			Help:      "Number of failed database queries by SQLSTATE class.",
+		}, []string{"class"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "transactions_total",
+			Help:      "Number of finished transactions by result.",
+		}, []string{"result"}),
+		poolHits:       poolDesc(opts.Namespace, "hits_total", "Number of times free connection was found in the pool."),
+		poolMisses:     poolDesc(opts.Namespace, "misses_total", "Number of times free connection was not found in the pool."),
+		poolTimeouts:   poolDesc(opts.Namespace, "timeouts_total", "Number of times a wait timeout occurred."),
+		poolTotalConns: poolDesc(opts.Namespace, "total_conns", "Number of total connections in the pool."),
+		poolIdleConns:  poolDesc(opts.Namespace, "idle_conns", "Number of idle connections in the pool."),
+		poolStaleConns: poolDesc(opts.Namespace, "stale_conns", "Number of stale connections removed from the pool."),
+	}
+	m.tables = make(map[string]bool, len(opts.Tables))
+	for _, t := range opts.Tables {
+		m.tables[t] = true
+	}
+
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func poolDesc(namespace, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", name), help, nil, nil)
+}
+
+// WithMetrics installs query hook which collects metrics, replicas of cluster client are instrumented too
+func WithMetrics(m *Metrics) Option {
+	return func(ctx context.Context) context.Context {
+		dbc := optionClient(ctx)
+		if dbc == nil {
+			return ctx
+		}
+		dbc.Db().AddQueryHook(m)
+		if c, ok := dbc.(*clusterClient); ok {
+			m.replicas.Do(func() {
+				for _, r := range c.Replicas() {
+					r.Client().Db().AddQueryHook(m)
+				}
+			})
+		}
+		return ctx
+	}
+}
+
+// Describe ...
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.errors.Describe(ch)
+	m.transactions.Describe(ch)
+	ch <- m.poolHits
+	ch <- m.poolMisses
+	ch <- m.poolTimeouts
+	ch <- m.poolTotalConns
+	ch <- m.poolIdleConns
+	ch <- m.poolStaleConns
+}
+
+// Collect ...
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.errors.Collect(ch)
+	m.transactions.Collect(ch)
+
+	stats := m.client.Db().PoolStats()
+	ch <- prometheus.MustNewConstMetric(m.poolHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(m.poolMisses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(m.poolTimeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(m.poolTotalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(m.poolIdleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(m.poolStaleConns, prometheus.GaugeValue, float64(stats.StaleConns))
+}
+
+// BeforeQuery ...
+func (m *Metrics) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterQuery ...
+func (m *Metrics) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	if isExplain(ctx) || isImplicitTx(ctx) {
+		return nil
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		return nil
+	}
+
+	values := make([]string, 0, len(m.labels))
+	for _, l := range m.labels {
+		switch l {
+		case LabelOperation:
+			values = append(values, string(statementKind(query)))
+		case LabelTable:
+			values = append(values, m.table(operationTable(event.Model, query)))
+		default:
+			values = append(values, "")
+		}
+	}
+	m.duration.WithLabelValues(values...).Observe(time.Since(event.StartTime).Seconds())
+
+	if event.Err != nil && event.Err != pg.ErrNoRows {
+		m.errors.WithLabelValues(sqlStateClass(ctx, event.Err)).Inc()
+	}
+
+	if _, ok := event.DB.(*pg.Tx); ok {
+		switch {
+		case strings.EqualFold(query, "COMMIT") && event.Err == nil:
+			m.transactions.WithLabelValues("commit").Inc()
+		case strings.EqualFold(query, "COMMIT"), strings.EqualFold(query, "ROLLBACK"):
+			m.transactions.WithLabelValues("rollback").Inc()
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) table(name string) string {
+	if name != "" && !m.tables[name] {
+		return otherTable
+	}
+	return name
+}
+
+// sqlStateClass returns the first two characters of SQLSTATE or "unknown" for non-database errors
+func sqlStateClass(ctx context.Context, err error) string {
+	code := pkgerr.Convert(ctx, err).Code()
+	if len(code) < 2 {
+		return "unknown"
+	}
+	return code[:2]
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string            { return string(e) }
+func (e sqlStateError) IntegrityViolation() bool { return false }
+func (e sqlStateError) Field(field byte) string {
+	if field == 'C' {
+		return string(e)
+	}
+	return ""
+}
+
+func TestMetrics(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg, client, NewMetricsOptions().WithTables("agent"))
+	assert.Nil(t, err)
+
+	query := func(db interface{}, query string, err error) {
+		event := &pg.QueryEvent{StartTime: time.Now(), Query: query, Err: err}
+		if tx, ok := db.(*pg.Tx); ok {
+			event.DB = tx
+		}
+		ctx, _ := m.BeforeQuery(context.Background(), event)
+		m.AfterQuery(ctx, event)
+	}
+
+	tx := &pg.Tx{}
+	query(nil, "SELECT * FROM agent", nil)
+	query(nil, "SELECT * FROM account", nil)
+	query(nil, "INSERT INTO agent (id) VALUES (1)", sqlStateError("23505"))
+	query(tx, "COMMIT", nil)
+	query(tx, "ROLLBACK", nil)
+	query(tx, "COMMIT", sqlStateError("40001"))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m, "db_query_errors_total"))
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP db_query_errors_total Number of failed database queries by SQLSTATE class.
+# TYPE db_query_errors_total counter
+db_query_errors_total{class="23"} 1
+db_query_errors_total{class="40"} 1
+# HELP db_transactions_total Number of finished transactions by result.
+# TYPE db_transactions_total counter
+db_transactions_total{result="commit"} 1
+db_transactions_total{result="rollback"} 2
+# HELP db_pool_total_conns Number of total connections in the pool.
+# TYPE db_pool_total_conns gauge
+db_pool_total_conns 0
+`), "db_query_errors_total", "db_transactions_total", "db_pool_total_conns"))
+
+	assert.Equal(t, 4, testutil.CollectAndCount(m, "db_query_duration_seconds"))
+}
+
+func TestMetricsDefaultTables(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	m, err := NewMetrics(prometheus.NewRegistry(), client, nil)
+	assert.Nil(t, err)
+
+	for _, query := range []string{"SELECT * FROM agent", "SELECT * FROM account", "SELECT 1"} {
+		m.AfterQuery(context.Background(), &pg.QueryEvent{StartTime: time.Now(), Query: query})
+	}
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m, "db_query_duration_seconds"))
+	assert.Equal(t, uint64(2), histogramCount(t, m.duration.WithLabelValues("select", otherTable)))
+}
+
+func TestMetricsClusterReplicas(t *testing.T) {
+	replica := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	client := NewClusterClient(NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"})), []IClient{replica},
+		NewClusterOptions().WithHealthCheckInterval(0))
+	defer client.Close()
+
+	m, err := NewMetrics(prometheus.NewRegistry(), client, NewMetricsOptions().WithTables("agent"))
+	assert.Nil(t, err)
+
+	var ctx context.Context
+	for i := 0; i < 2; i++ {
+		ctx = NewContext(context.Background(), client.WrapWithContext(context.Background()), WithMetrics(m))
+	}
+	_, err = FromContext(ctx).Query(nil, "SELECT * FROM agent")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, uint64(1), histogramCount(t, m.duration.WithLabelValues("select", "agent")))
+}
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	var metric dto.Metric
+	assert.Nil(t, o.(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
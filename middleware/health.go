@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	db "github.com/sanches1984/gopkg-pg-orm"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheck reports database health
+type healthCheck func(ctx context.Context) (*db.Health, error)
+
+func clientHealthCheck(dbClient db.IClient) healthCheck {
+	return func(ctx context.Context) (*db.Health, error) {
+		return db.CheckHealth(ctx, dbClient)
+	}
+}
+
+type healthResponse struct {
+	Status        string  `json:"status"`
+	LatencyMs     float64 `json:"latency_ms"`
+	ServerVersion string  `json:"server_version,omitempty"`
+	InRecovery    bool    `json:"in_recovery"`
+	Pool          *pool   `json:"pool,omitempty"`
+}
+
+type pool struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+}
+
+// NewHealthHandler returns http handler which reports database health as JSON,
+// responds with 503 status code if database is not available, the error is logged only
+func NewHealthHandler(dbClient db.IClient) http.Handler {
+	return newHealthHandler(clientHealthCheck(dbClient))
+}
+
+func newHealthHandler(check healthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{Status: "ok"}
+		code := http.StatusOK
+
+		health, err := check(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("database health check failed")
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		} else {
+			resp.LatencyMs = float64(health.Latency.Microseconds()) / 1000
+			resp.ServerVersion = health.ServerVersion
+			resp.InRecovery = health.InRecovery
+			resp.Pool = &pool{
+				Hits:       health.PoolStats.Hits,
+				Misses:     health.PoolStats.Misses,
+				Timeouts:   health.PoolStats.Timeouts,
+				TotalConns: health.PoolStats.TotalConns,
+				IdleConns:  health.PoolStats.IdleConns,
+				StaleConns: health.PoolStats.StaleConns,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	check healthCheck
+}
+
+// NewHealthServer returns gRPC health service which reports SERVING while database is available
+func NewHealthServer(dbClient db.IClient) grpc_health_v1.HealthServer {
+	return &healthServer{check: clientHealthCheck(dbClient)}
+}
+
+// Check ...
+func (s *healthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	status := grpc_health_v1.HealthCheckResponse_SERVING
+	if _, err := s.check(ctx); err != nil {
+		log.Error().Err(err).Msg("database health check failed")
+		status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: status}, nil
+}
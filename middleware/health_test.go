@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	db "github.com/sanches1984/gopkg-pg-orm"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Run("Available", func(t *testing.T) {
+		check := func(ctx context.Context) (*db.Health, error) {
+			return &db.Health{
+				Latency:       1500 * time.Microsecond,
+				ServerVersion: "13.4",
+				PoolStats:     &pg.PoolStats{TotalConns: 2, IdleConns: 1},
+			}, nil
+		}
+
+		rec := httptest.NewRecorder()
+		newHealthHandler(check).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status":"ok","latency_ms":1.5,"server_version":"13.4","in_recovery":false,
+			"pool":{"hits":0,"misses":0,"timeouts":0,"total_conns":2,"idle_conns":1,"stale_conns":0}}`, rec.Body.String())
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		check := func(ctx context.Context) (*db.Health, error) {
+			return nil, errors.New(`FATAL: password authentication failed for user "app" on host "10.0.0.1"`)
+		}
+
+		rec := httptest.NewRecorder()
+		newHealthHandler(check).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `{"status":"unavailable","latency_ms":0,"in_recovery":false}`, rec.Body.String())
+
+		resp, err := (&healthServer{check: check}).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		assert.Nil(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+	})
+}
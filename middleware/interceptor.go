@@ -12,11 +12,13 @@ import (
 // NewDBServerInterceptor wrap endpoint with middleware mixing in db connection
 func NewDBServerInterceptor(dbClient db.IClient, option ...db.Option) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx = db.NewRouteContext(ctx, info.FullMethod)
 		return handler(db.NewContext(ctx, dbClient.WrapWithContext(ctx), option...), req)
 	}
 }
 
-// NewDBServerMiddleware wrap endpoint with middleware mixing in db connection
+// NewDBServerMiddleware wrap endpoint with middleware mixing in db connection,
+// route template can be stored in request context by NewRouteMiddleware chained before it
 func NewDBServerMiddleware(dbClient db.IClient, option ...db.Option) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -26,3 +28,23 @@ func NewDBServerMiddleware(dbClient db.IClient, option ...db.Option) func(next h
 		})
 	}
 }
+
+// NewNamedDBServerInterceptor wrap endpoint with middleware mixing in db connection registered by name
+func NewNamedDBServerInterceptor(name string, dbClient db.IClient, option ...db.Option) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx = db.NewRouteContext(ctx, info.FullMethod)
+		return handler(db.NewContextNamed(ctx, name, dbClient.WrapWithContext(ctx), option...), req)
+	}
+}
+
+// NewNamedDBServerMiddleware wrap endpoint with middleware mixing in db connection registered by name,
+// route template can be stored in request context by NewRouteMiddleware chained before it
+func NewNamedDBServerMiddleware(name string, dbClient db.IClient, option ...db.Option) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			r = r.WithContext(db.NewContextNamed(ctx, name, dbClient.WrapWithContext(ctx), option...))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
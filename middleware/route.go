@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	db "github.com/sanches1984/gopkg-pg-orm"
+)
+
+// RouteFunc returns route template of request provided by router, e.g. "/agents/{id}"
+type RouteFunc func(r *http.Request) string
+
+// NewRouteMiddleware stores route template returned by route in request context, it is reported in sqlcommenter comments.
+// Template must not contain request values, so that comments keep low cardinality, empty route is not stored.
+func NewRouteMiddleware(route RouteFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if template := route(r); template != "" {
+				r = r.WithContext(db.NewRouteContext(r.Context(), template))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
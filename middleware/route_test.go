@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	db "github.com/sanches1984/gopkg-pg-orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMiddleware(t *testing.T) {
+	client := db.NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	var route string
+	handler := NewDBServerMiddleware(client)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route = db.RouteFromContext(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/agents/42", nil))
+	assert.Equal(t, "", route, "raw path is not reported")
+
+	withRoute := NewRouteMiddleware(func(r *http.Request) string { return "/agents/{id}" })(handler)
+	withRoute.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/agents/42", nil))
+	assert.Equal(t, "/agents/{id}", route)
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	db "github.com/sanches1984/gopkg-pg-orm"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrSchemaNotAllowed is returned by SchemaAllowlist validator for schema which is not listed
+var ErrSchemaNotAllowed = errors.New("schema is not allowed")
+
+// SchemaValidator checks that schema requested by caller may be used by the request,
+// e.g. that it belongs to authenticated tenant. Request is rejected if it returns error.
+type SchemaValidator func(ctx context.Context, schema string) error
+
+// SchemaAllowlist returns validator which allows listed schemas only
+func SchemaAllowlist(schemas ...string) SchemaValidator {
+	allowed := make(map[string]bool, len(schemas))
+	for _, s := range schemas {
+		allowed[s] = true
+	}
+	return func(ctx context.Context, schema string) error {
+		if !allowed[schema] {
+			return ErrSchemaNotAllowed
+		}
+		return nil
+	}
+}
+
+// NewDBSchemaServerInterceptor wrap endpoint with middleware mixing in db connection bound to schema,
+// schema name is taken from incoming metadata key and checked by validate, nil validate rejects every schema.
+// Request with schema which is not valid is rejected with PermissionDenied code.
+func NewDBSchemaServerInterceptor(dbClient db.IClient, key string, validate SchemaValidator, option ...db.Option) grpc.UnaryServerInterceptor {
+	option = append([]db.Option{db.WithSchema(db.SchemaFromContext)}, option...)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx = db.NewRouteContext(ctx, info.FullMethod)
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(key); len(values) > 0 && values[0] != "" {
+				if err := validateSchema(ctx, validate, values[0]); err != nil {
+					return nil, status.Error(codes.PermissionDenied, ErrSchemaNotAllowed.Error())
+				}
+				ctx = db.NewSchemaContext(ctx, values[0])
+			}
+		}
+		return handler(db.NewContext(ctx, dbClient.WrapWithContext(ctx), option...), req)
+	}
+}
+
+// NewDBSchemaServerMiddleware wrap endpoint with middleware mixing in db connection bound to schema,
+// schema name is taken from request header and checked by validate, nil validate rejects every schema.
+// Request with schema which is not valid is rejected with 403 status code.
+func NewDBSchemaServerMiddleware(dbClient db.IClient, header string, validate SchemaValidator, option ...db.Option) func(next http.Handler) http.Handler {
+	option = append([]db.Option{db.WithSchema(db.SchemaFromContext)}, option...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if schema := r.Header.Get(header); schema != "" {
+				if err := validateSchema(ctx, validate, schema); err != nil {
+					http.Error(w, ErrSchemaNotAllowed.Error(), http.StatusForbidden)
+					return
+				}
+				ctx = db.NewSchemaContext(ctx, schema)
+			}
+			r = r.WithContext(db.NewContext(ctx, dbClient.WrapWithContext(ctx), option...))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validateSchema checks schema requested by caller, the error is logged only
+func validateSchema(ctx context.Context, validate SchemaValidator, schema string) error {
+	err := ErrSchemaNotAllowed
+	if validate != nil {
+		err = validate(ctx, schema)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("schema", schema).Msg("schema is rejected")
+	}
+	return err
+}
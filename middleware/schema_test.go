@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	db "github.com/sanches1984/gopkg-pg-orm"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSchemaMiddleware(t *testing.T) {
+	client := db.NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	var schema string
+	handler := NewDBSchemaServerMiddleware(client, "X-Tenant", SchemaAllowlist("tenant_a"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema = db.FromContext(r.Context()).Schema()
+	}))
+	serve := func(tenant string) int {
+		schema = "-"
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("tenant_a"))
+	assert.Equal(t, "tenant_a", schema)
+	assert.Equal(t, http.StatusOK, serve(""))
+	assert.Equal(t, "", schema)
+	assert.Equal(t, http.StatusForbidden, serve("tenant_b"))
+	assert.Equal(t, "-", schema, "handler is not called")
+
+	noValidator := NewDBSchemaServerMiddleware(client, "X-Tenant", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "tenant_a")
+	rec := httptest.NewRecorder()
+	noValidator.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestSchemaInterceptor(t *testing.T) {
+	client := db.NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	interceptor := NewDBSchemaServerInterceptor(client, "x-tenant", SchemaAllowlist("tenant_a"))
+	call := func(tenant string) (interface{}, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", tenant))
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/agent.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return db.FromContext(ctx).Schema(), nil
+		})
+	}
+
+	resp, err := call("tenant_a")
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant_a", resp)
+
+	resp, err = call("tenant_b")
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
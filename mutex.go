@@ -1,55 +1,326 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
 )
 
-// Mutex is a shared mutex, stored in database
+const (
+	// DefaultLockMinBackoff default backoff before the second Lock attempt
+	DefaultLockMinBackoff = 50 * time.Millisecond
+	// DefaultLockMaxBackoff default max backoff between Lock attempts
+	DefaultLockMaxBackoff = time.Second
+)
+
+// heldLockCondition matches advisory lock with objsubid, classid, objid and mode in pg_locks held by the current session
+const heldLockCondition = `pid = pg_backend_pid() AND locktype = 'advisory'
+	AND objsubid = ? AND classid::bigint = ? AND objid::bigint = ? AND mode = ?`
+
+// ErrLockLost is returned when database session holding the lock is lost, e.g. after connection reset,
+// the lock is released by the server then
+var ErrLockLost = errors.New("mutex lock is lost")
+
+// ErrLockMode is returned when RWMutex is locked or unlocked in mode other than the lock is held in
+var ErrLockMode = errors.New("mutex lock is held in other mode")
+
+// MutexOptions mutex options
+type MutexOptions struct {
+	// LockTimeout bounds each server-side wait of Lock, Lock retries with backoff after timeout.
+	// Zero means Lock waits in a single attempt until the lock is acquired or context ends.
+	LockTimeout time.Duration
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewMutexOptions create mutex options with defaults
+func NewMutexOptions() *MutexOptions {
+	return &MutexOptions{
+		MinBackoff: DefaultLockMinBackoff,
+		MaxBackoff: DefaultLockMaxBackoff,
+	}
+}
+
+// WithLockTimeout update options with new lockTimeout value
+func (o *MutexOptions) WithLockTimeout(timeout time.Duration) *MutexOptions {
+	o.LockTimeout = timeout
+	return o
+}
+
+// WithBackoff update options with new backoff bounds
+func (o *MutexOptions) WithBackoff(min, max time.Duration) *MutexOptions {
+	o.MinBackoff = min
+	o.MaxBackoff = max
+	return o
+}
+
+// Mutex is a shared mutex, stored in database.
+// The lock is held by a connection pinned from the pool until Unlock.
+// Mutex created by string key can be logged with zerolog Object,
+// acquire, release and loss of the lock are logged with its key.
 type Mutex struct {
-	db     *pg.DB
-	mu     sync.Mutex
-	lockID int64
+	sessionLock
 }
 
 // NewMutex creates a new mutex
 func NewMutex(db *pg.DB, id int64) (*Mutex, error) {
-	if db.Options().PoolSize > 1 {
-		return nil, errors.New("pool size for mutex cannot be greater than 1")
+	return NewMutexWithOptions(db, id, nil)
+}
+
+// NewMutexWithOptions creates a new mutex with options
+func NewMutexWithOptions(db *pg.DB, id int64, opts *MutexOptions) (*Mutex, error) {
+	m := &Mutex{}
+	m.init(db, opts, id, nil)
+	return m, nil
+}
+
+// NewMutexForKey creates a new mutex locked by string key in namespace
+func NewMutexForKey(db *pg.DB, namespace, key string) (*Mutex, error) {
+	return NewMutexForKeyWithOptions(db, namespace, key, nil)
+}
+
+// NewMutexForKeyWithOptions creates a new mutex locked by string key in namespace with options
+func NewMutexForKeyWithOptions(db *pg.DB, namespace, key string, opts *MutexOptions) (*Mutex, error) {
+	lockKey, err := NewLockKey(namespace, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Mutex{db: db, lockID: id}, nil
+	m := &Mutex{}
+	m.init(db, opts, 0, &lockKey)
+	return m, nil
+}
+
+// Lock waits until the lock is acquired or ctx ends. Server-side wait is cancelled with ctx.
+// If the lock is already held, its session is checked and ErrLockLost is returned if the session is lost.
+func (m *Mutex) Lock(ctx context.Context) error {
+	return m.lockMode(ctx, false)
 }
 
-// TryLock tries to acquire a lock and returns true in case of success, otherwise returns false
+// TryLock tries to acquire a lock and returns true in case of success, otherwise returns false.
+// If the lock is already held, its session is checked and ErrLockLost is returned if the session is lost.
 func (m *Mutex) TryLock() (bool, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.tryLockMode(false)
+}
+
+// Unlock releases a lock in database and returns the connection to the pool
+func (m *Mutex) Unlock() error {
+	return m.unlockMode(false)
+}
+
+// sessionLock is an advisory lock held in exclusive or shared mode by a session pinned from the pool
+type sessionLock struct {
+	db     *pg.DB
+	opts   *MutexOptions
+	mu     sync.Mutex
+	lock   advisoryLock
+	lockID int64
+	// key is set if the lock is created by string key
+	key *LockKey
+	// conn is a session holding the lock, nil if the lock is not held
+	conn *pg.Conn
+	pid  int32
+	// shared is true if the lock is held in shared mode
+	shared bool
+}
+
+func (s *sessionLock) init(db *pg.DB, opts *MutexOptions, id int64, key *LockKey) {
+	if opts == nil {
+		opts = NewMutexOptions()
+	}
+	s.db, s.opts, s.lockID, s.key = db, opts, id, key
+	if key != nil {
+		s.lock = pairLock(key.IDs())
+	} else {
+		s.lock = bigintLock(id)
+	}
+}
+
+// Key returns string key of the lock, false is returned if the lock is created by id
+func (s *sessionLock) Key() (LockKey, bool) {
+	if s.key == nil {
+		return LockKey{}, false
+	}
+	return *s.key, true
+}
+
+// String ...
+func (s *sessionLock) String() string {
+	if s.key != nil {
+		return s.key.String()
+	}
+	return strconv.FormatInt(s.lockID, 10)
+}
+
+// MarshalZerologObject ...
+func (s *sessionLock) MarshalZerologObject(e *zerolog.Event) {
+	if s.key != nil {
+		s.key.MarshalZerologObject(e)
+		return
+	}
+	e.Int64("id", s.lockID)
+}
+
+// Check returns ErrLockLost if session holding the lock is lost, nil is returned if the lock is not held
+func (s *sessionLock) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.check()
+}
+
+func (s *sessionLock) lockMode(ctx context.Context, shared bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		if s.shared != shared {
+			return ErrLockMode
+		}
+		return s.check()
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(Backoff(attempt-1, s.opts.MinBackoff, s.opts.MaxBackoff))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		err := s.acquire(ctx, shared)
+		if err == nil {
+			s.logEvent(log.Debug(), "mutex lock acquired")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if pkgerr.Convert(ctx, err).Code() != pkgerr.CodeLockNotAvailable {
+			return err
+		}
+	}
+}
+
+// acquire waits for the lock on a new session, the session is returned to the pool if the lock is not acquired
+func (s *sessionLock) acquire(ctx context.Context, shared bool) error {
+	conn := s.db.Conn()
+
+	var lockTimeout string
+	var pid int32
+	_, err := conn.QueryOneContext(ctx, pg.Scan(&lockTimeout, &pid), "SELECT set_config('lock_timeout', ?, false), pg_backend_pid()",
+		strconv.FormatInt(timeoutMillis(s.opts.LockTimeout), 10))
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "SELECT "+s.lock.call(lockFunc("pg_advisory_lock", shared)), s.lock.params()...)
+	}
 
-	res, err := m.db.Exec("SELECT 1 FROM pg_locks WHERE pid=pg_backend_pid() AND locktype='advisory' AND objid=?", m.lockID)
+	// lock_timeout is reset and the lock acquired concurrently with cancellation is released
+	// before the session is returned to the pool
+	if _, resetErr := conn.Exec("RESET lock_timeout"); err == nil {
+		err = resetErr
+	}
 	if err != nil {
-		return false, err
+		conn.Exec("SELECT "+s.lock.call(lockFunc("pg_advisory_unlock", shared))+" FROM pg_locks WHERE "+heldLockCondition,
+			s.lock.params(s.lock.held(shared)...)...)
+		conn.Close()
+		return err
 	}
-	if res.RowsReturned() > 0 {
+
+	s.conn, s.pid, s.shared = conn, pid, shared
+	return nil
+}
+
+func (s *sessionLock) tryLockMode(shared bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		if s.shared != shared {
+			return false, ErrLockMode
+		}
+		if err := s.check(); err != nil {
+			return false, err
+		}
 		return true, nil
 	}
 
+	conn := s.db.Conn()
 	var isLocked bool
-	_, err = m.db.Query(&isLocked, "SELECT pg_try_advisory_lock(?)", m.lockID)
-	if err != nil {
+	var pid int32
+	_, err := conn.QueryOne(pg.Scan(&isLocked, &pid), "SELECT "+s.lock.call(lockFunc("pg_try_advisory_lock", shared))+", pg_backend_pid()",
+		s.lock.params()...)
+	if err != nil || !isLocked {
+		conn.Close()
 		return false, err
 	}
 
-	return isLocked, nil
+	s.conn, s.pid, s.shared = conn, pid, shared
+	s.logEvent(log.Debug(), "mutex lock acquired")
+	return true, nil
 }
 
-// Unlock releases a lock in database
-func (m *Mutex) Unlock() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (s *sessionLock) unlockMode(shared bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	if s.shared != shared {
+		return ErrLockMode
+	}
+
+	var isUnlocked bool
+	var pid int32
+	_, err := s.conn.QueryOne(pg.Scan(&isUnlocked, &pid), "SELECT "+s.lock.call(lockFunc("pg_advisory_unlock", shared))+", pg_backend_pid()",
+		s.lock.params()...)
+	if err != nil || !isUnlocked || pid != s.pid {
+		s.logEvent(log.Warn().Err(err), "mutex lock lost")
+		s.release()
+		return ErrLockLost
+	}
+	s.logEvent(log.Debug(), "mutex lock released")
+	s.release()
+	return nil
+}
+
+// check verifies that the lock is still held by the same session, the lock is released if it is lost
+func (s *sessionLock) check() error {
+	var isLocked bool
+	var pid int32
+	_, err := s.conn.QueryOne(pg.Scan(&isLocked, &pid),
+		"SELECT EXISTS (SELECT 1 FROM pg_locks WHERE "+heldLockCondition+"), pg_backend_pid()",
+		s.lock.held(s.shared)...)
+	if err != nil || !isLocked || pid != s.pid {
+		s.logEvent(log.Warn().Err(err), "mutex lock lost")
+		s.release()
+		return ErrLockLost
+	}
+	return nil
+}
+
+// logEvent logs event of the held lock with its key, mode and session
+func (s *sessionLock) logEvent(e *zerolog.Event, msg string) {
+	e.Object("lock", s).Str("mode", lockMode(s.shared)).Int32("pid", s.pid).Msg(msg)
+}
 
-	_, err := m.db.Exec("SELECT pg_advisory_unlock(?) ", m.lockID)
-	return err
+// release returns pinned connection to the pool
+func (s *sessionLock) release() {
+	s.conn.Close()
+	s.conn = nil
+	s.pid = 0
+	s.shared = false
 }
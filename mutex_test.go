@@ -3,11 +3,16 @@
 package database
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -54,11 +59,53 @@ func TestMutex_TryLock(t *testing.T) {
 		c.PoolSize = 10
 
 		conn := pg.Connect(&c)
+		defer conn.Close()
 
-		_, err := NewMutex(conn, 111)
-		if err == nil {
-			t.Error("NewMutex is expected to return error, but got nil")
-		}
+		mu1, err := NewMutex(conn, 111)
+		assert.Nil(t, err)
+		mu2, err := NewMutex(conn, 111)
+		assert.Nil(t, err)
+
+		ok, err := mu1.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = mu1.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = mu2.TryLock()
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		assert.Nil(t, mu1.Unlock())
+		assert.Equal(t, conn.PoolStats().TotalConns, conn.PoolStats().IdleConns)
+
+		ok, err = mu2.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Nil(t, mu2.Unlock())
+	})
+
+	t.Run("Lost session", func(t *testing.T) {
+		c := cfg
+		c.PoolSize = 2
+
+		conn := pg.Connect(&c)
+		defer conn.Close()
+
+		mu, err := NewMutex(conn, 333)
+		assert.Nil(t, err)
+
+		ok, err := mu.TryLock()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		_, err = conn.Exec("SELECT pg_terminate_backend(?)", mu.pid)
+		assert.Nil(t, err)
+
+		assert.Equal(t, ErrLockLost, mu.Check())
+		assert.Nil(t, mu.Unlock())
 	})
 }
 
@@ -80,3 +127,142 @@ func TestMutex_Unlock(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestMutex_Lock(t *testing.T) {
+	c := cfg
+	c.PoolSize = 4
+
+	conn := pg.Connect(&c)
+	defer conn.Close()
+
+	holder, err := NewMutex(conn, 444)
+	assert.Nil(t, err)
+	ok, err := holder.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	t.Run("Cancel", func(t *testing.T) {
+		mu, err := NewMutex(conn, 444)
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, mu.Lock(ctx))
+
+		var waiting int
+		_, err = conn.QueryOne(pg.Scan(&waiting), "SELECT count(*) FROM pg_locks WHERE locktype = 'advisory' AND NOT granted")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, waiting)
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		mu, err := NewMutexWithOptions(conn, 444, NewMutexOptions().
+			WithLockTimeout(20*time.Millisecond).
+			WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+		assert.Nil(t, err)
+
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			holder.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.Nil(t, mu.Lock(ctx))
+		assert.Nil(t, mu.Unlock())
+	})
+}
+
+func TestMutexForKey(t *testing.T) {
+	c := cfg
+	c.PoolSize = 4
+
+	conn := pg.Connect(&c)
+	defer conn.Close()
+
+	var logs bytes.Buffer
+	defer func(logger zerolog.Logger) { log.Logger = logger }(log.Logger)
+	log.Logger = zerolog.New(&logs).Level(zerolog.DebugLevel)
+
+	mu, err := NewMutexForKey(conn, "test", "report")
+	assert.Nil(t, err)
+	ok, err := mu.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Contains(t, logs.String(), `"lock":{"namespace":"test","key":"report"}`)
+	assert.Contains(t, logs.String(), "mutex lock acquired")
+
+	other, err := NewMutexForKey(conn, "other", "report")
+	assert.Nil(t, err)
+	ok, err = other.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, other.Unlock())
+
+	same, err := NewMutexForKey(conn, "test", "report")
+	assert.Nil(t, err)
+	ok, err = same.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	held, err := HeldLockKeys(context.Background(), conn, "test", "report", "export")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"report"}, held)
+
+	assert.Nil(t, mu.Check())
+	assert.Nil(t, mu.Unlock())
+	assert.Contains(t, logs.String(), "mutex lock released")
+
+	held, err = HeldLockKeys(context.Background(), conn, "test", "report", "export")
+	assert.Nil(t, err)
+	assert.Empty(t, held)
+}
+
+func TestRWMutex(t *testing.T) {
+	c := cfg
+	c.PoolSize = 4
+
+	conn := pg.Connect(&c)
+	defer conn.Close()
+
+	reader1, err := NewRWMutex(conn, 555)
+	assert.Nil(t, err)
+	reader2, err := NewRWMutex(conn, 555)
+	assert.Nil(t, err)
+	writer, err := NewRWMutex(conn, 555)
+	assert.Nil(t, err)
+
+	ok, err := reader1.TryRLock()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, reader2.RLock(context.Background()))
+
+	ok, err = writer.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, ErrLockMode, reader1.Unlock())
+	_, err = reader1.TryLock()
+	assert.Equal(t, ErrLockMode, err)
+	assert.Nil(t, reader1.Check())
+
+	assert.Nil(t, reader1.RUnlock())
+	assert.Nil(t, reader2.RUnlock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, writer.Lock(ctx))
+
+	ok, err = reader1.TryRLock()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, reader1.RLock(ctx))
+	})
+
+	assert.Equal(t, ErrLockMode, writer.RUnlock())
+	assert.Nil(t, writer.Unlock())
+}
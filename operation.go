@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// OpKind is a kind of client operation
+type OpKind string
+
+const (
+	// OpSelect is a SELECT statement
+	OpSelect OpKind = "select"
+	// OpInsert is an INSERT statement
+	OpInsert OpKind = "insert"
+	// OpUpdate is an UPDATE statement
+	OpUpdate OpKind = "update"
+	// OpDelete is a DELETE statement
+	OpDelete OpKind = "delete"
+	// OpExec is any other statement
+	OpExec OpKind = "exec"
+	// OpCopyFrom is a COPY FROM statement
+	OpCopyFrom OpKind = "copy_from"
+	// OpCopyTo is a COPY TO statement
+	OpCopyTo OpKind = "copy_to"
+)
+
+var tableRegexp = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|JOIN|COPY)\s+((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)`)
+
+// Operation describes client operation passed to interceptors
+type Operation struct {
+	Kind  OpKind
+	Table string
+	// Query is formatted with values of sensitive columns masked by redact.Default
+	Query string
+	Model interface{}
+	InTx  bool
+}
+
+// Interceptor wraps client operation, next must be called to run the operation
+type Interceptor func(ctx context.Context, op *Operation, next func() (orm.Result, error)) (orm.Result, error)
+
+// chainInterceptors runs interceptors in order, the first one is the outermost
+func chainInterceptors(interceptors []Interceptor, ctx context.Context, op *Operation, fn func() (orm.Result, error)) (orm.Result, error) {
+	next := fn
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, proceed := interceptors[i], next
+		next = func() (orm.Result, error) {
+			return interceptor(ctx, op, proceed)
+		}
+	}
+	return next()
+}
+
+// queryTemplate returns query text without formatted params
+func queryTemplate(query interface{}) string {
+	switch typed := query.(type) {
+	case orm.TemplateAppender:
+		if b, err := typed.AppendTemplate(nil); err == nil {
+			return string(b)
+		}
+	case string:
+		return typed
+	}
+	return ""
+}
+
+// statementKind returns operation kind by the leading keyword of query
+func statementKind(query string) OpKind {
+	switch strings.ToLower(leadingKeyword(query)) {
+	case "select":
+		return OpSelect
+	case "insert":
+		return OpInsert
+	case "update":
+		return OpUpdate
+	case "delete":
+		return OpDelete
+	}
+	return OpExec
+}
+
+// leadingKeyword returns the first word of query
+func leadingKeyword(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	if end := strings.IndexAny(query, " \t\r\n(;"); end >= 0 {
+		query = query[:end]
+	}
+	return query
+}
+
+// operationTable returns table name by model or, if model has no table, by query
+func operationTable(model interface{}, query string) string {
+	if name := modelTableName(model); name != "" {
+		return name
+	}
+	if m := tableRegexp.FindStringSubmatch(query); m != nil {
+		return unquoteIdent(m[1])
+	}
+	return ""
+}
+
+func unquoteIdent(name string) string {
+	return strings.Replace(name, `"`, "", -1)
+}
+
+func modelTableName(model interface{}) string {
+	if model == nil {
+		return ""
+	}
+	if tm, ok := model.(orm.TableModel); ok {
+		if t := tm.Table(); t != nil {
+			return unquoteIdent(string(t.FullName))
+		}
+		return ""
+	}
+
+	typ := reflect.TypeOf(model)
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return ""
+	}
+	return unquoteIdent(string(orm.GetTable(typ).FullName))
+}
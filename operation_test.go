@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+type operationModel struct {
+	tableName struct{} `pg:"agent"`
+	ID        int64
+}
+
+func TestChainInterceptors(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) Interceptor {
+		return func(ctx context.Context, op *Operation, next func() (orm.Result, error)) (orm.Result, error) {
+			calls = append(calls, name+":before")
+			res, err := next()
+			calls = append(calls, name+":after")
+			return res, err
+		}
+	}
+
+	_, err := chainInterceptors(
+		[]Interceptor{interceptor("first"), interceptor("second")},
+		context.Background(),
+		&Operation{Kind: OpSelect},
+		func() (orm.Result, error) {
+			calls = append(calls, "op")
+			return nil, nil
+		},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first:before", "second:before", "op", "second:after", "first:after"}, calls)
+}
+
+func TestStatementKind(t *testing.T) {
+	assert.Equal(t, OpSelect, statementKind("SELECT 1"))
+	assert.Equal(t, OpSelect, statementKind(" (select id from agent)"))
+	assert.Equal(t, OpInsert, statementKind("INSERT INTO agent"))
+	assert.Equal(t, OpUpdate, statementKind("update agent set name = ?"))
+	assert.Equal(t, OpDelete, statementKind("DELETE FROM agent"))
+	assert.Equal(t, OpExec, statementKind("CREATE TABLE agent"))
+	assert.Equal(t, OpExec, statementKind(""))
+}
+
+func TestOperationTable(t *testing.T) {
+	assert.Equal(t, "agent", operationTable(&operationModel{}, ""))
+	assert.Equal(t, "agent", operationTable(&[]operationModel{}, ""))
+	assert.Equal(t, "agent", operationTable(nil, `SELECT * FROM "agent" WHERE id = 1`))
+	assert.Equal(t, "public.agent", operationTable(nil, `INSERT INTO public.agent (id) VALUES (1)`))
+	assert.Equal(t, "", operationTable(nil, "SELECT 1"))
+}
+
+type secretModel struct {
+	tableName struct{} `pg:"account"`
+	ID        int64
+	APIKey    string `pgorm:",sensitive"`
+}
+
+func TestOperationQueryRedacted(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+
+	errStop := errors.New("stop")
+	var queries []string
+	client.Use(func(ctx context.Context, op *Operation, next func() (orm.Result, error)) (orm.Result, error) {
+		queries = append(queries, op.Query)
+		return nil, errStop
+	})
+
+	_, err := client.Exec("UPDATE account SET password = ? WHERE id = ?", "s3cret", 1)
+	assert.Equal(t, errStop, err)
+	_, err = client.Query(&secretModel{}, "SELECT * FROM account WHERE api_key = ?", "k3y")
+	assert.Equal(t, errStop, err)
+	err = client.Insert(&secretModel{ID: 1, APIKey: "k3y"})
+	assert.Equal(t, errStop, err)
+	err = client.Model(&secretModel{}).Where("api_key = ?", "k3y").Where("id = ?", 111).Select()
+	assert.Equal(t, errStop, err)
+
+	assert.Equal(t, []string{
+		`UPDATE account SET password = '***' WHERE id = 1`,
+		`SELECT * FROM account WHERE api_key = '***'`,
+		`INSERT INTO account ("id", "api_key") VALUES (1, '***')`,
+		`SELECT "secret_model"."id", "secret_model"."api_key" FROM account AS "secret_model" WHERE (api_key = '***') AND (id = 111)`,
+	}, queries)
+}
@@ -8,15 +8,28 @@ import (
 
 type Option func(ctx context.Context) context.Context
 
+// WithLogger logs every query if duration is zero, otherwise only queries running longer than duration,
+// failed queries are always logged
 func WithLogger(logger zerolog.Logger, duration time.Duration) Option {
-	logger.Info().Dur("over", duration).Msg("long db query logging enabled")
+	opts := NewLoggerOptions().WithSlowThreshold(duration)
+	if duration != 0 {
+		opts.SuccessLevel = zerolog.Disabled
+	}
+	return WithLoggerOptions(logger, opts)
+}
+
+// WithLoggerOptions logs queries with structured fields and levels configured by opts.
+// Sampling, rate limits and EXPLAIN state is shared by all contexts the option is applied to.
+func WithLoggerOptions(logger zerolog.Logger, opts *LoggerOptions) Option {
+	logger.Info().Dur("over", opts.SlowThreshold).Msg("long db query logging enabled")
+	dbLogger := newDBLogger(logger, opts)
 	return func(ctx context.Context) context.Context {
-		dbLogger := newDBLogger(logger, duration)
-		dbc := FromContext(ctx)
+		dbc := optionClient(ctx)
 		if dbc == nil {
 			return ctx
 		}
-		dbc.Db().AddQueryHook(dbLogger)
+		// hook is bound to db of the client, so EXPLAIN runs on the same database
+		dbc.Db().AddQueryHook(dbLoggerHook{dbLogger: dbLogger, db: dbc.Db()})
 		return context.WithValue(ctx, &dbLoggerKey, dbLogger)
 	}
 }
@@ -0,0 +1,322 @@
+package redact
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/go-pg/pg/v9/types"
+)
+
+// Mask replaces sensitive values
+const Mask = "***"
+
+const (
+	tagName         = "pgorm"
+	sensitiveOption = "sensitive"
+)
+
+var (
+	// Default masks columns named password, secret or token
+	Default = New("password", "secret", "token")
+
+	insertRegexp  = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\S+(?:\s+AS\s+\S+)?\s*\(([^)]*)\)\s*VALUES\s*`)
+	compareRegexp = regexp.MustCompile(`(?i)((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)\s*(?:=|<>|!=|<=|>=|<|>|\bI?LIKE|\bIN)\s*\(?\s*$`)
+	messageRegexp = regexp.MustCompile(`(for (?:type|enum) [^:]+: )"(?:[^"]|"")*"|(Key \([^)]*\)=\().*?(\) )`)
+
+	sensitiveColumns sync.Map
+)
+
+// Param is a query parameter prepared for logging
+type Param struct {
+	// Column the parameter is compared with or inserted into, empty if unknown
+	Column string
+	Value  string
+}
+
+// String ...
+func (p Param) String() string {
+	if p.Column == "" {
+		return p.Value
+	}
+	return p.Column + "=" + p.Value
+}
+
+// Redactor masks query parameters bound to sensitive columns
+type Redactor struct {
+	columns map[string]bool
+}
+
+// New creates redactor which masks denied columns and columns of query model fields tagged with `pgorm:",sensitive"`,
+// column is denied if its name is equal to or ends with "_" and one of columns.
+// Params of unformatted queries are masked by Params and MaskParams, formatted queries, e.g. rendered by ORM,
+// are masked by MaskQuery.
+func New(columns ...string) *Redactor {
+	r := &Redactor{columns: make(map[string]bool, len(columns))}
+	for _, c := range columns {
+		r.columns[strings.ToLower(c)] = true
+	}
+	return r
+}
+
+// Params returns params of unformatted query, values of sensitive columns are masked
+func (r *Redactor) Params(query string, model interface{}, params []interface{}) []Param {
+	if len(params) == 0 {
+		return nil
+	}
+
+	sensitive := SensitiveColumns(model)
+	result := make([]Param, len(params))
+	for i, param := range params {
+		result[i].Value = string(types.Append(nil, param, 1))
+	}
+	for _, p := range placeholders(query) {
+		if p.index >= len(params) || p.column == "" {
+			continue
+		}
+		result[p.index].Column = p.column
+		if sensitive[p.column] || r.denied(p.column) {
+			result[p.index].Value = Mask
+		}
+	}
+	return result
+}
+
+// MaskParams returns copy of params of unformatted query, values of sensitive columns are replaced with Mask
+func (r *Redactor) MaskParams(query string, model interface{}, params []interface{}) []interface{} {
+	if len(params) == 0 {
+		return params
+	}
+
+	sensitive := SensitiveColumns(model)
+	result := append([]interface{}(nil), params...)
+	for _, p := range placeholders(query) {
+		if p.index < len(params) && p.column != "" && (sensitive[p.column] || r.denied(p.column)) {
+			result[p.index] = Mask
+		}
+	}
+	return result
+}
+
+// MaskQuery returns formatted query with literals compared with or inserted into sensitive columns replaced with Mask
+func (r *Redactor) MaskQuery(query string, model interface{}) string {
+	sensitive := SensitiveColumns(model)
+	var b strings.Builder
+	last := 0
+	for _, l := range scan(query, true) {
+		if l.column == "" || !sensitive[l.column] && !r.denied(l.column) {
+			continue
+		}
+		b.WriteString(query[last:l.start])
+		b.WriteString("'" + Mask + "'")
+		last = l.end
+	}
+	if last == 0 {
+		return query
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+func (r *Redactor) denied(column string) bool {
+	if r == nil {
+		return false
+	}
+	if r.columns[column] {
+		return true
+	}
+	if i := strings.LastIndexByte(column, '_'); i >= 0 {
+		return r.columns[column[i+1:]]
+	}
+	return false
+}
+
+// Message masks values quoted in postgres error message
+func Message(msg string) string {
+	return messageRegexp.ReplaceAllStringFunc(msg, func(m string) string {
+		sub := messageRegexp.FindStringSubmatch(m)
+		if sub[1] != "" {
+			return sub[1] + `"` + Mask + `"`
+		}
+		return sub[2] + Mask + sub[3]
+	})
+}
+
+// SensitiveColumns returns columns of model fields tagged with `pgorm:",sensitive"`
+func SensitiveColumns(model interface{}) map[string]bool {
+	if tm, ok := model.(orm.TableModel); ok {
+		if t := tm.Table(); t != nil {
+			return sensitiveTableColumns(t.Type)
+		}
+		return nil
+	}
+	if model == nil {
+		return nil
+	}
+
+	typ := reflect.TypeOf(model)
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return sensitiveTableColumns(typ)
+}
+
+func sensitiveTableColumns(typ reflect.Type) map[string]bool {
+	if v, ok := sensitiveColumns.Load(typ); ok {
+		return v.(map[string]bool)
+	}
+
+	columns := make(map[string]bool)
+	for _, f := range orm.GetTable(typ).Fields {
+		if isSensitive(f.Field.Tag.Get(tagName)) {
+			columns[f.SQLName] = true
+		}
+	}
+	sensitiveColumns.Store(typ, columns)
+	return columns
+}
+
+func isSensitive(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, option := range strings.Split(tag, ",")[1:] {
+		if strings.TrimSpace(option) == sensitiveOption {
+			return true
+		}
+	}
+	return false
+}
+
+type placeholder struct {
+	index  int
+	column string
+	// start and end are bounds of literal in query
+	start, end int
+}
+
+// placeholders returns positional placeholders of query with columns they are bound to
+func placeholders(query string) []placeholder {
+	return scan(query, false)
+}
+
+// scan returns positional placeholders or, if literals is true, string and numeric literals of query
+// with columns they are bound to
+func scan(query string, literals bool) []placeholder {
+	var insertColumns []string
+	valuesStart := -1
+	if m := insertRegexp.FindStringSubmatchIndex(query); m != nil {
+		for _, c := range strings.Split(query[m[2]:m[3]], ",") {
+			insertColumns = append(insertColumns, column(c))
+		}
+		valuesStart = m[1]
+	}
+
+	bind := func(p placeholder, depth, columnIdx int) placeholder {
+		if depth == 1 {
+			if columnIdx < len(insertColumns) {
+				p.column = insertColumns[columnIdx]
+			}
+		} else if m := compareRegexp.FindStringSubmatch(query[:p.start]); m != nil {
+			p.column = column(m[1])
+		}
+		return p
+	}
+
+	var result []placeholder
+	var next, depth, columnIdx int
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			start := i
+			i = quoteEnd(query, i)
+			if literals && c == '\'' {
+				result = append(result, bind(placeholder{start: start, end: i + 1}, depth, columnIdx))
+			}
+		case literals && isDigit(c) && (i == 0 || !isIdent(query[i-1])):
+			start := i
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			result = append(result, bind(placeholder{start: start, end: i + 1}, depth, columnIdx))
+		case c == '(':
+			if valuesStart >= 0 && i >= valuesStart {
+				depth++
+				if depth == 1 {
+					columnIdx = 0
+				}
+			}
+		case c == ')':
+			if valuesStart >= 0 && i >= valuesStart && depth > 0 {
+				depth--
+			}
+		case c == ',':
+			if depth == 1 {
+				columnIdx++
+			}
+		case c == '?' && !literals:
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			p := placeholder{start: i, end: end}
+			switch {
+			case end > i+1:
+				p.index, _ = strconv.Atoi(query[i+1 : end])
+			case end < len(query) && (query[end] == '_' || isLetter(query[end])):
+				// named placeholder
+				continue
+			default:
+				p.index = next
+				next++
+			}
+
+			result = append(result, bind(p, depth, columnIdx))
+			i = end - 1
+		}
+	}
+	return result
+}
+
+// quoteEnd returns index of quote closing the one at i, doubled quotes are escaped
+func quoteEnd(query string, i int) int {
+	c := query[i]
+	for j := i + 1; j < len(query); j++ {
+		if query[j] != c {
+			continue
+		}
+		if j+1 < len(query) && query[j+1] == c {
+			j++
+			continue
+		}
+		return j
+	}
+	return len(query) - 1
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || isLetter(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// column returns unquoted lower-case column name without table prefix
+func column(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Replace(name, `"`, "", -1))
+}
@@ -0,0 +1,57 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type account struct {
+	ID     int64
+	Login  string
+	Secret string `pgorm:",sensitive"`
+}
+
+func TestParams(t *testing.T) {
+	r := New("password")
+
+	params := r.Params(`SELECT * FROM account WHERE "a"."login" = ? AND password = ?`, nil, []interface{}{"bob", "qwerty"})
+	assert.Equal(t, []Param{{Column: "login", Value: "'bob'"}, {Column: "password", Value: Mask}}, params)
+
+	params = r.Params(`INSERT INTO account (id, "login", secret) VALUES (DEFAULT, ?, ?), (DEFAULT, ?, ?)`, &account{}, []interface{}{"bob", "s1", "ann", "s2"})
+	assert.Equal(t, []string{"login='bob'", "secret=***", "login='ann'", "secret=***"}, strs(params))
+
+	params = r.Params(`UPDATE account SET user_password = ?1, note = '?' WHERE id = ?0`, nil, []interface{}{1, "qwerty"})
+	assert.Equal(t, []string{"id=1", "user_password=***"}, strs(params))
+
+	params = r.Params(`SELECT ?, ?name`, nil, []interface{}{"x"})
+	assert.Equal(t, []string{"'x'"}, strs(params))
+
+	assert.Nil(t, r.Params(`SELECT 1`, nil, nil))
+}
+
+func TestMaskQuery(t *testing.T) {
+	r := New("password")
+
+	assert.Equal(t, `INSERT INTO "account" AS "a" ("id", "login", "secret") VALUES (1, 'bob', '***'), (DEFAULT, 'it''s', '***')`,
+		r.MaskQuery(`INSERT INTO "account" AS "a" ("id", "login", "secret") VALUES (1, 'bob', 's1'), (DEFAULT, 'it''s', 's''2')`, &account{}))
+	assert.Equal(t, `UPDATE "account" SET "user_password" = '***', "login" = 'bob' WHERE "a"."id" = 42`,
+		r.MaskQuery(`UPDATE "account" SET "user_password" = 'qwerty', "login" = 'bob' WHERE "a"."id" = 42`, nil))
+	assert.Equal(t, `SELECT * FROM "account" AS "a" WHERE (a.secret = '***') AND (t1.id = 2) LIMIT 1`,
+		r.MaskQuery(`SELECT * FROM "account" AS "a" WHERE (a.secret = 12.5) AND (t1.id = 2) LIMIT 1`, &account{}))
+	assert.Equal(t, `SELECT 1`, r.MaskQuery(`SELECT 1`, nil))
+}
+
+func TestMessage(t *testing.T) {
+	assert.Equal(t, `invalid input syntax for type uuid: "***"`, Message(`invalid input syntax for type uuid: "my-secret"`))
+	assert.Equal(t, `Key (email)=(***) already exists.`, Message(`Key (email)=(bob@example.com) already exists.`))
+	assert.Equal(t, `relation "account" does not exist`, Message(`relation "account" does not exist`))
+}
+
+func strs(params []Param) []string {
+	result := make([]string, 0, len(params))
+	for _, p := range params {
+		result = append(result, p.String())
+	}
+	return result
+}
@@ -17,39 +17,66 @@ import (
 )
 
 // DAO is a data access object
-type DAO struct{}
+type DAO struct {
+	dbName string
+}
 
 // New creates new DAO structure
 func New() *DAO {
 	return &DAO{}
 }
 
+// NewNamed creates new DAO structure bound to database registered in context by name
+func NewNamed(name string) *DAO {
+	return &DAO{dbName: name}
+}
+
+func (r *DAO) client(ctx context.Context) db.IClient {
+	return db.FromContextNamed(ctx, r.dbName)
+}
+
 // DeletedSetter is an interface
 type DeletedSetter interface {
 	SetDeleted(time.Time)
 }
 
 func (r *DAO) Ping(ctx context.Context) error {
-	_, err := db.FromContext(ctx).Exec("SELECT 1")
+	_, err := r.client(ctx).Exec("SELECT 1")
 	return err
 }
 
-// WithTX executes passed function within transaction
+// WithTX executes passed function within transaction, nested calls are executed within savepoints
 func (r *DAO) WithTX(ctx context.Context, fn func(context.Context) error) error {
-	dbc := db.FromContext(ctx)
+	return r.WithTXOptions(ctx, nil, fn)
+}
+
+// WithTXOptions executes passed function within transaction started with opts,
+// nested calls must not request options which differ from outer transaction
+func (r *DAO) WithTXOptions(ctx context.Context, opts *db.TxOptions, fn func(context.Context) error) error {
+	dbc := r.client(ctx)
 	if dbc.Tx() != nil {
-		return fn(ctx)
+		if err := opts.CheckNested(dbc.TxOptions()); err != nil {
+			return pkgerr.NewBadRequestError(err).WithMessage(err.Error())
+		}
+		return r.withSavepoint(ctx, dbc, fn)
 	}
 
-	dbcTx, err := dbc.StartTransaction()
+	dbcTx, err := dbc.StartTransactionWithOptions(opts)
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
 
-	ctxTx := db.NewContext(ctx, dbcTx)
+	defer func() {
+		if r := recover(); r != nil {
+			dbcTx.Rollback()
+			panic(r)
+		}
+	}()
+
+	ctxTx := db.NewContextNamed(ctx, r.dbName, dbcTx)
 	err = fn(ctxTx)
 	if err != nil {
-		rollbackErr := dbcTx.Tx().Rollback()
+		rollbackErr := dbcTx.Rollback()
 		if rollbackErr != nil {
 			// todo get logger from context
 			log.Error().Err(pkgerr.Convert(ctx, rollbackErr)).Msg("failed to rollback transaction")
@@ -58,7 +85,37 @@ func (r *DAO) WithTX(ctx context.Context, fn func(context.Context) error) error
 		return err
 	}
 
-	err = dbcTx.Tx().Commit()
+	err = dbcTx.Commit()
+	if err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+	return nil
+}
+
+func (r *DAO) withSavepoint(ctx context.Context, dbc db.IClient, fn func(context.Context) error) error {
+	sp, err := db.StartSavepoint(dbc)
+	if err != nil {
+		return pkgerr.Convert(ctx, err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			sp.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(ctx)
+	if err != nil {
+		rollbackErr := sp.Rollback()
+		if rollbackErr != nil {
+			log.Error().Err(pkgerr.Convert(ctx, rollbackErr)).Msg("failed to rollback savepoint")
+		}
+
+		return err
+	}
+
+	err = sp.Release()
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -67,7 +124,7 @@ func (r *DAO) WithTX(ctx context.Context, fn func(context.Context) error) error
 
 // FindOne selects the only record from database according to opts
 func (r *DAO) FindOne(ctx context.Context, receiver interface{}, opts []opt.FnOpt) error {
-	err := db.FromContext(ctx).Model(receiver).Apply(opt.Apply(opts...)).First()
+	err := r.client(ctx).Model(receiver).Apply(opt.Apply(opts...)).First()
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -77,7 +134,7 @@ func (r *DAO) FindOne(ctx context.Context, receiver interface{}, opts []opt.FnOp
 
 // FindList selects all records from database according to opts
 func (r *DAO) FindList(ctx context.Context, receiver interface{}, opts []opt.FnOpt) error {
-	q := db.FromContext(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
+	q := r.client(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
 
 	err := q.Apply(opt.ApplyPaging(opts...)).Select()
 	if err != nil {
@@ -89,7 +146,7 @@ func (r *DAO) FindList(ctx context.Context, receiver interface{}, opts []opt.FnO
 
 // FindListWithTotal selects all records and total count of records from database according to opts
 func (r *DAO) FindListWithTotal(ctx context.Context, receiver interface{}, opts []opt.FnOpt) (int, error) {
-	q := db.FromContext(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
+	q := r.client(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
 	total, err := q.Count()
 	if err != nil {
 		return 0, pkgerr.Convert(ctx, err)
@@ -105,7 +162,7 @@ func (r *DAO) FindListWithTotal(ctx context.Context, receiver interface{}, opts
 
 // GetTotal get total count of records from database according to opts
 func (r *DAO) GetTotal(ctx context.Context, receiver interface{}, opts []opt.FnOpt) (int, error) {
-	q := db.FromContext(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
+	q := r.client(ctx).Model(receiver).Apply(opt.ApplyFilter(opts...))
 	total, err := q.Count()
 	if err != nil {
 		return 0, pkgerr.Convert(ctx, err)
@@ -117,7 +174,7 @@ func (r *DAO) GetTotal(ctx context.Context, receiver interface{}, opts []opt.FnO
 // Update updates a record
 func (r *DAO) Update(ctx context.Context, rec interface{}, columns ...string) error {
 	columns = append(columns, "updated")
-	q := db.FromContext(ctx).Model(rec).Column(columns...)
+	q := r.client(ctx).Model(rec).Column(columns...)
 	// Slice not require additional filter
 	if reflect.ValueOf(rec).Elem().Type().Kind() != reflect.Slice {
 		q.WherePK()
@@ -136,7 +193,7 @@ func (r *DAO) UpdateWhere(ctx context.Context, rec interface{}, opts []opt.FnOpt
 		return pkgerr.NewInternalError(fmt.Errorf("UpdateWhere: setFieldValuePairs must be even, got %d", len(setFieldValuePairs)))
 	}
 	setFieldValuePairs = append(setFieldValuePairs, "updated", time.Now())
-	q := db.FromContext(ctx).Model(rec).Apply(opt.ApplyFilter(opts...))
+	q := r.client(ctx).Model(rec).Apply(opt.ApplyFilter(opts...))
 	for i := 0; i < len(setFieldValuePairs); i += 2 {
 		column, ok := setFieldValuePairs[i].(string)
 		if !ok {
@@ -155,7 +212,7 @@ func (r *DAO) UpdateWhere(ctx context.Context, rec interface{}, opts []opt.FnOpt
 // UpdateWithReturning updates a record
 func (r *DAO) UpdateWithReturning(ctx context.Context, rec interface{}, columns ...string) error {
 	columns = append(columns, "updated")
-	_, err := db.FromContext(ctx).Model(rec).Column(columns...).WherePK().Returning("*").Update()
+	_, err := r.client(ctx).Model(rec).Column(columns...).WherePK().Returning("*").Update()
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -165,7 +222,7 @@ func (r *DAO) UpdateWithReturning(ctx context.Context, rec interface{}, columns
 
 // Insert creates a new record
 func (r *DAO) Insert(ctx context.Context, rec ...interface{}) error {
-	err := db.FromContext(ctx).Insert(rec...)
+	err := r.client(ctx).Insert(rec...)
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -186,7 +243,7 @@ func (r *DAO) SoftDelete(ctx context.Context, rec DeletedSetter) error {
 
 // HardDelete removes record from database
 func (r *DAO) HardDelete(ctx context.Context, rec interface{}) error {
-	err := db.FromContext(ctx).Delete(rec)
+	err := r.client(ctx).Delete(rec)
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -196,7 +253,7 @@ func (r *DAO) HardDelete(ctx context.Context, rec interface{}) error {
 
 // HardDeleteWhere removes record from database
 func (r *DAO) HardDeleteWhere(ctx context.Context, rec interface{}, opts []opt.FnOpt) error {
-	_, err := db.FromContext(ctx).Model(rec).Apply(opt.ApplyFilter(opts...)).Delete()
+	_, err := r.client(ctx).Model(rec).Apply(opt.ApplyFilter(opts...)).Delete()
 	if err != nil {
 		return pkgerr.Convert(ctx, err)
 	}
@@ -237,7 +294,7 @@ func (r *DAO) Upsert(ctx context.Context, recs interface{}, keys []string, colum
 		return pkgerr.NewBadRequestError(errors.New("models cannot be empty"))
 	}
 
-	dbc := db.FromContext(ctx)
+	dbc := r.client(ctx)
 	q := dbc.Model(&models).OnConflict("(" + strings.Join(keys, ",") + ") DO UPDATE")
 
 	for _, column := range columns {
@@ -15,6 +15,7 @@ import (
 	"github.com/sanches1984/gopkg-pg-orm/repository/opt"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -57,6 +58,86 @@ func TestRepository_WithTX(t *testing.T) {
 
 		assert.Equal(t, pg.ErrNoRows, err, "Transaction not work")
 	})
+
+	t.Run("Nested error", func(t *testing.T) {
+		test.CleanDB(testCtx, t)
+		r := &DAO{}
+
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			dbc := db.FromContext(ctx)
+			err := dbc.Insert(&Agent{ID: 111, Name: "test-tx"})
+			assert.Nil(t, err)
+
+			err = r.WithTX(ctx, func(ctx context.Context) error {
+				dbc := db.FromContext(ctx)
+				err := dbc.Insert(&Agent{ID: 222, Name: "test-nested-tx"})
+				assert.Nil(t, err)
+				return pkgerr.NewInternalError(errors.New("error"))
+			})
+			assert.NotNil(t, err)
+			return nil
+		})
+
+		assert.Nil(t, err)
+
+		dbc := db.FromContext(testCtx)
+		got := &Agent{ID: 111}
+		err = dbc.Select(got)
+		assert.Nil(t, err)
+
+		err = dbc.Select(&Agent{ID: 222})
+		assert.Equal(t, pg.ErrNoRows, err, "Savepoint not work")
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		test.CleanDB(testCtx, t)
+		r := &DAO{}
+
+		assert.Panics(t, func() {
+			r.WithTX(testCtx, func(ctx context.Context) error {
+				err := db.FromContext(ctx).Insert(&Agent{ID: 111, Name: "test-tx"})
+				assert.Nil(t, err)
+				panic("fn failed")
+			})
+		})
+
+		err := db.FromContext(testCtx).Select(&Agent{ID: 111})
+		assert.Equal(t, pg.ErrNoRows, err, "Transaction is not rolled back")
+	})
+}
+
+func TestRepository_WithTXOptions(t *testing.T) {
+	r := &DAO{}
+	opts := &db.TxOptions{Isolation: db.Serializable, ReadOnly: true}
+
+	t.Run("Success", func(t *testing.T) {
+		err := r.WithTXOptions(testCtx, opts, func(ctx context.Context) error {
+			dbc := db.FromContext(ctx)
+			assert.Equal(t, opts, dbc.TxOptions())
+
+			var isolation, readOnly string
+			_, err := dbc.QueryOne(pg.Scan(&isolation), "SHOW transaction_isolation")
+			assert.Nil(t, err)
+			_, err = dbc.QueryOne(pg.Scan(&readOnly), "SHOW transaction_read_only")
+			assert.Nil(t, err)
+
+			assert.Equal(t, "serializable", isolation)
+			assert.Equal(t, "on", readOnly)
+			return nil
+		})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("Nested mismatch", func(t *testing.T) {
+		err := r.WithTXOptions(testCtx, opts, func(ctx context.Context) error {
+			return r.WithTXOptions(ctx, &db.TxOptions{Isolation: db.RepeatableRead}, func(ctx context.Context) error {
+				return nil
+			})
+		})
+
+		assert.True(t, pkgerr.IsBadRequest(err))
+	})
 }
 
 func TestRepository_FindOne_FindList(t *testing.T) {
@@ -434,3 +515,252 @@ func TestRepository_UpsertSliceWithDoubles(t *testing.T) {
 	err = dbc.Select(got)
 	assert.Equal(t, name12, got.Name)
 }
+
+type serializationError struct{}
+
+func (serializationError) Error() string            { return "could not serialize access" }
+func (serializationError) IntegrityViolation() bool { return false }
+func (serializationError) Field(field byte) string {
+	if field == 'C' {
+		return pkgerr.CodeSerializationFailure
+	}
+	return ""
+}
+
+func TestRepository_WithRetryTX(t *testing.T) {
+	r := &DAO{}
+	opts := NewRetryOptions().WithMaxAttempts(3).WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+	t.Run("Retry until success", func(t *testing.T) {
+		attempts := 0
+		err := r.WithRetryTX(testCtx, opts, func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return serializationError{}
+			}
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("Not retryable", func(t *testing.T) {
+		attempts := 0
+		err := r.WithRetryTX(testCtx, opts, func(ctx context.Context) error {
+			attempts++
+			return pkgerr.NewInternalError(errors.New("error"))
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		attempts := 0
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			return r.WithRetryTX(ctx, opts, func(ctx context.Context) error {
+				attempts++
+				return serializationError{}
+			})
+		})
+
+		assert.True(t, pkgerr.IsRetryable(testCtx, err))
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestRepository_WithTX_Hooks(t *testing.T) {
+	r := &DAO{}
+
+	t.Run("Commit", func(t *testing.T) {
+		var calls []string
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			db.OnCommit(ctx, func() { calls = append(calls, "commit1") })
+			db.OnRollback(ctx, func() { calls = append(calls, "rollback") })
+
+			err := r.WithTX(ctx, func(ctx context.Context) error {
+				db.OnCommit(ctx, func() { calls = append(calls, "commit2") })
+				return nil
+			})
+			assert.Empty(t, calls)
+			return err
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"commit1", "commit2"}, calls)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		var calls []string
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			db.OnCommit(ctx, func() { calls = append(calls, "commit") })
+			db.OnRollback(ctx, func() { calls = append(calls, "rollback") })
+			return pkgerr.NewInternalError(errors.New("error"))
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{"rollback"}, calls)
+	})
+
+	t.Run("Nested rollback", func(t *testing.T) {
+		var calls []string
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			db.OnCommit(ctx, func() { calls = append(calls, "commit1") })
+
+			err := r.WithTX(ctx, func(ctx context.Context) error {
+				db.OnCommit(ctx, func() { calls = append(calls, "commit2") })
+				db.OnRollback(ctx, func() { calls = append(calls, "rollback2") })
+				return pkgerr.NewInternalError(errors.New("error"))
+			})
+			assert.NotNil(t, err)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"rollback2", "commit1"}, calls)
+	})
+
+	t.Run("Without transaction", func(t *testing.T) {
+		called := false
+		db.OnCommit(testCtx, func() { called = true })
+		assert.True(t, called)
+	})
+}
+
+func TestRepository_WithSchema(t *testing.T) {
+	r := &DAO{}
+	dbc := db.FromContext(testCtx)
+	_, err := dbc.Exec("CREATE SCHEMA IF NOT EXISTS tenant_test")
+	assert.Nil(t, err)
+
+	ctx := db.NewContext(db.NewSchemaContext(testCtx, "tenant_test"), dbc, db.WithSchema(db.SchemaFromContext))
+	assert.Equal(t, "tenant_test", db.FromContext(ctx).Schema())
+
+	err = r.WithTX(ctx, func(ctx context.Context) error {
+		var searchPath string
+		_, err := db.FromContext(ctx).QueryOne(pg.Scan(&searchPath), "SHOW search_path")
+		assert.Equal(t, "tenant_test", searchPath)
+		return err
+	})
+	assert.Nil(t, err)
+
+	var schema string
+	_, err = db.FromContext(ctx).QueryOne(pg.Scan(&schema), "SELECT '?SCHEMA'")
+	assert.Nil(t, err)
+	assert.Equal(t, `"tenant_test"`, schema)
+
+	t.Run("Without transaction", func(t *testing.T) {
+		_, err := dbc.Exec("CREATE TABLE IF NOT EXISTS tenant_test.tenant_probe (id int)")
+		assert.Nil(t, err)
+		defer dbc.Exec("DROP TABLE tenant_test.tenant_probe")
+
+		var searchPath string
+		_, err = db.FromContext(ctx).QueryOne(pg.Scan(&searchPath), "SHOW search_path")
+		assert.Nil(t, err)
+		assert.Equal(t, "tenant_test", searchPath)
+
+		var probes []struct {
+			tableName struct{} `pg:"tenant_probe"`
+			ID        int
+		}
+		err = db.FromContext(ctx).Model(&probes).Select()
+		assert.Nil(t, err)
+		assert.Empty(t, probes)
+	})
+
+	var searchPath string
+	_, err = dbc.QueryOne(pg.Scan(&searchPath), "SHOW search_path")
+	assert.Nil(t, err)
+	assert.NotEqual(t, "tenant_test", searchPath, "search_path leaked")
+}
+
+func TestRepository_Named(t *testing.T) {
+	test.CleanDB(testCtx, t)
+	dbc := db.FromContext(testCtx)
+	ctx := db.NewContextNamed(context.Background(), "analytics", dbc)
+	assert.Nil(t, db.FromContext(ctx))
+
+	r := NewNamed("analytics")
+	err := r.WithTX(ctx, func(ctx context.Context) error {
+		assert.NotNil(t, db.FromContextNamed(ctx, "analytics").Tx())
+		return r.Insert(ctx, &Agent{ID: 111, Name: "test-named"})
+	})
+	assert.Nil(t, err)
+
+	var got Agent
+	err = r.FindOne(ctx, &got, opt.List(opt.Eq("id", 111)))
+	assert.Nil(t, err)
+	assert.Equal(t, "test-named", got.Name)
+}
+
+func TestRepository_Interceptors(t *testing.T) {
+	test.CleanDB(testCtx, t)
+	r := &DAO{}
+
+	var ops []db.Operation
+	dbc := db.FromContext(testCtx).WrapWithContext(testCtx)
+	dbc.Use(func(ctx context.Context, op *db.Operation, next func() (orm.Result, error)) (orm.Result, error) {
+		ops = append(ops, *op)
+		return next()
+	})
+	ctx := db.NewContext(testCtx, dbc)
+
+	err := r.Insert(ctx, &Agent{ID: 111, Name: "test-interceptor"})
+	assert.Nil(t, err)
+	err = r.FindOne(ctx, &Agent{}, opt.List(opt.Eq("id", 111)))
+	assert.Nil(t, err)
+
+	if assert.Len(t, ops, 2) {
+		assert.Equal(t, db.OpInsert, ops[0].Kind)
+		assert.Equal(t, "agent", ops[0].Table)
+		assert.Equal(t, db.OpSelect, ops[1].Kind)
+		assert.Contains(t, ops[1].Query, "111")
+		assert.False(t, ops[1].InTx)
+	}
+}
+
+func TestRepository_LockXact(t *testing.T) {
+	r := &DAO{}
+
+	t.Run("Outside transaction", func(t *testing.T) {
+		err := r.LockXact(testCtx, 555)
+		assert.True(t, pkgerr.IsBadRequest(err))
+
+		_, err = r.TryLockXact(testCtx, 555)
+		assert.True(t, pkgerr.IsBadRequest(err))
+	})
+
+	t.Run("Released on commit", func(t *testing.T) {
+		// other session is checked on a dedicated connection, as pooled session can be reused
+		// by the next transaction and advisory locks are re-entrant
+		conn := db.FromContext(testCtx).Db().Conn()
+		defer conn.Close()
+		tryLock := func() bool {
+			var ok bool
+			_, err := conn.QueryOne(pg.Scan(&ok), "SELECT pg_try_advisory_lock(555)")
+			assert.Nil(t, err)
+			if ok {
+				_, err = conn.Exec("SELECT pg_advisory_unlock(555)")
+				assert.Nil(t, err)
+			}
+			return ok
+		}
+
+		err := r.WithTX(testCtx, func(ctx context.Context) error {
+			if err := r.LockXact(ctx, 555); err != nil {
+				return err
+			}
+			assert.False(t, tryLock(), "lock is held by transaction")
+			return nil
+		})
+		assert.Nil(t, err)
+
+		var held int
+		_, err = db.FromContext(testCtx).QueryOne(pg.Scan(&held),
+			"SELECT count(*) FROM pg_locks WHERE locktype = 'advisory' AND objid = 555")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, held)
+		assert.True(t, tryLock(), "lock is released on commit")
+	})
+}
@@ -0,0 +1,31 @@
+package dao
+
+import (
+	"context"
+
+	db "github.com/sanches1984/gopkg-pg-orm"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+)
+
+// LockXact waits for advisory lock which is released on commit or rollback of transaction started by WithTX,
+// bad request error is returned if it is called outside of transaction
+func (r *DAO) LockXact(ctx context.Context, key int64) error {
+	return convertLockErr(ctx, db.LockXactNamed(ctx, r.dbName, key))
+}
+
+// TryLockXact tries to acquire advisory lock which is released on commit or rollback of transaction
+// started by WithTX and returns true in case of success
+func (r *DAO) TryLockXact(ctx context.Context, key int64) (bool, error) {
+	ok, err := db.TryLockXactNamed(ctx, r.dbName, key)
+	return ok, convertLockErr(ctx, err)
+}
+
+func convertLockErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == db.ErrNoTransaction {
+		return pkgerr.NewBadRequestError(err).WithMessage(err.Error())
+	}
+	return pkgerr.Convert(ctx, err)
+}
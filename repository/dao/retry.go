@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	db "github.com/sanches1984/gopkg-pg-orm"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+)
+
+const (
+	// DefaultRetryMaxAttempts default max attempts of transaction execution
+	DefaultRetryMaxAttempts = 3
+	// DefaultRetryMinBackoff default backoff before the first retry
+	DefaultRetryMinBackoff = 10 * time.Millisecond
+	// DefaultRetryMaxBackoff default max backoff between retries
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// RetryOptions transaction retry options
+type RetryOptions struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	TxOptions   *db.TxOptions
+	Retryable   func(ctx context.Context, err error) bool
+}
+
+// NewRetryOptions create retry options with defaults
+func NewRetryOptions() *RetryOptions {
+	return &RetryOptions{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		MinBackoff:  DefaultRetryMinBackoff,
+		MaxBackoff:  DefaultRetryMaxBackoff,
+		Retryable:   pkgerr.IsRetryable,
+	}
+}
+
+// WithMaxAttempts update options with new maxAttempts value
+func (o *RetryOptions) WithMaxAttempts(maxAttempts int) *RetryOptions {
+	o.MaxAttempts = maxAttempts
+	return o
+}
+
+// WithBackoff update options with new backoff bounds
+func (o *RetryOptions) WithBackoff(min, max time.Duration) *RetryOptions {
+	o.MinBackoff = min
+	o.MaxBackoff = max
+	return o
+}
+
+// WithTxOptions update options with transaction options
+func (o *RetryOptions) WithTxOptions(opts *db.TxOptions) *RetryOptions {
+	o.TxOptions = opts
+	return o
+}
+
+// WithRetryable update options with retryable error classifier
+func (o *RetryOptions) WithRetryable(fn func(ctx context.Context, err error) bool) *RetryOptions {
+	o.Retryable = fn
+	return o
+}
+
+// WithRetryTX executes passed function within transaction and retries the whole transaction
+// if it failed with retryable error, nested calls are never retried
+func (r *DAO) WithRetryTX(ctx context.Context, opts *RetryOptions, fn func(context.Context) error) error {
+	if opts == nil {
+		opts = NewRetryOptions()
+	}
+	if r.client(ctx).Tx() != nil {
+		return r.WithTXOptions(ctx, opts.TxOptions, fn)
+	}
+
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = pkgerr.IsRetryable
+	}
+
+	var err error
+	for attempt := 0; attempt < opts.MaxAttempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(db.Backoff(attempt-1, opts.MinBackoff, opts.MaxBackoff))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return pkgerr.Convert(ctx, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		err = r.WithTXOptions(ctx, opts.TxOptions, fn)
+		if err == nil || !retryable(ctx, err) {
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,35 @@
+package database
+
+import "context"
+
+var requestIDKey = "requestID"
+
+// NewRequestIDContext returns a new Context that carries request ID
+func NewRequestIDContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, &requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns request ID stored in ctx
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(&requestIDKey).(string)
+	return requestID
+}
+
+var routeKey = "route"
+
+// NewRouteContext returns a new Context that carries route or gRPC method of request
+func NewRouteContext(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, &routeKey, route)
+}
+
+// RouteFromContext returns route stored in ctx
+func RouteFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	route, _ := ctx.Value(&routeKey).(string)
+	return route
+}
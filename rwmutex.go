@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// RWMutex is a reader/writer mutex, stored in database. The lock can be held by many readers
+// or by a single writer across processes. Like Mutex, the lock is held by a connection pinned
+// from the pool until RUnlock or Unlock. An RWMutex holds a single session, so concurrent readers
+// of one process use separate RWMutex values with the same id or key.
+type RWMutex struct {
+	sessionLock
+}
+
+// NewRWMutex creates a new reader/writer mutex
+func NewRWMutex(db *pg.DB, id int64) (*RWMutex, error) {
+	return NewRWMutexWithOptions(db, id, nil)
+}
+
+// NewRWMutexWithOptions creates a new reader/writer mutex with options
+func NewRWMutexWithOptions(db *pg.DB, id int64, opts *MutexOptions) (*RWMutex, error) {
+	m := &RWMutex{}
+	m.init(db, opts, id, nil)
+	return m, nil
+}
+
+// NewRWMutexForKey creates a new reader/writer mutex locked by string key in namespace
+func NewRWMutexForKey(db *pg.DB, namespace, key string) (*RWMutex, error) {
+	return NewRWMutexForKeyWithOptions(db, namespace, key, nil)
+}
+
+// NewRWMutexForKeyWithOptions creates a new reader/writer mutex locked by string key in namespace with options
+func NewRWMutexForKeyWithOptions(db *pg.DB, namespace, key string, opts *MutexOptions) (*RWMutex, error) {
+	lockKey, err := NewLockKey(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &RWMutex{}
+	m.init(db, opts, 0, &lockKey)
+	return m, nil
+}
+
+// Lock waits until the lock is acquired for writing or ctx ends, see Mutex.Lock.
+// ErrLockMode is returned if the lock is held for reading.
+func (m *RWMutex) Lock(ctx context.Context) error {
+	return m.lockMode(ctx, false)
+}
+
+// TryLock tries to acquire the lock for writing and returns true in case of success, see Mutex.TryLock.
+// ErrLockMode is returned if the lock is held for reading.
+func (m *RWMutex) TryLock() (bool, error) {
+	return m.tryLockMode(false)
+}
+
+// Unlock releases the lock held for writing, ErrLockMode is returned if the lock is held for reading
+func (m *RWMutex) Unlock() error {
+	return m.unlockMode(false)
+}
+
+// RLock waits until the lock is acquired for reading or ctx ends. Server-side wait is cancelled with ctx.
+// If the lock is already held for reading, its session is checked and ErrLockLost is returned if the session is lost.
+// ErrLockMode is returned if the lock is held for writing.
+func (m *RWMutex) RLock(ctx context.Context) error {
+	return m.lockMode(ctx, true)
+}
+
+// TryRLock tries to acquire the lock for reading and returns true in case of success, otherwise returns false.
+// If the lock is already held for reading, its session is checked and ErrLockLost is returned if the session is lost.
+// ErrLockMode is returned if the lock is held for writing.
+func (m *RWMutex) TryRLock() (bool, error) {
+	return m.tryLockMode(true)
+}
+
+// RUnlock releases the lock held for reading and returns the connection to the pool,
+// ErrLockMode is returned if the lock is held for writing
+func (m *RWMutex) RUnlock() error {
+	return m.unlockMode(true)
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// ErrNoTransaction is returned when an operation requires a transaction but client has none
+var ErrNoTransaction = errors.New("client is not in transaction")
+
+var savepointSeq uint64
+
+// Savepoint is a named savepoint within client transaction
+type Savepoint struct {
+	client IClient
+	name   string
+	hooks  *txHooks
+	mark   hooksMark
+	// stmtTimeout is statement_timeout cached by client, timeout is its value at savepoint
+	stmtTimeout *int64
+	timeout     int64
+}
+
+// StartSavepoint creates a new savepoint in the client transaction
+func StartSavepoint(client IClient) (*Savepoint, error) {
+	if client.Tx() == nil {
+		return nil, ErrNoTransaction
+	}
+
+	hooks := hooksOf(client)
+	sp := &Savepoint{
+		client: client,
+		name:   "sp_" + strconv.FormatUint(atomic.AddUint64(&savepointSeq, 1), 10),
+		hooks:  hooks,
+		mark:   hooks.mark(),
+	}
+	if sp.stmtTimeout = stmtTimeoutOf(client); sp.stmtTimeout != nil {
+		sp.timeout = atomic.LoadInt64(sp.stmtTimeout)
+	}
+	if _, err := client.Tx().Exec("SAVEPOINT ?", pg.Ident(sp.name)); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// Name returns savepoint name
+func (s *Savepoint) Name() string {
+	return s.name
+}
+
+// Release releases savepoint, keeping all changes made after it
+func (s *Savepoint) Release() error {
+	_, err := s.client.Tx().Exec("RELEASE SAVEPOINT ?", pg.Ident(s.name))
+	return err
+}
+
+// Rollback discards all changes and commit hooks made after savepoint and runs rollback hooks registered after it.
+// statement_timeout set locally after savepoint is reverted too.
+func (s *Savepoint) Rollback() error {
+	_, err := s.client.Tx().Exec("ROLLBACK TO SAVEPOINT ?", pg.Ident(s.name))
+	if err != nil {
+		return err
+	}
+
+	if s.stmtTimeout != nil {
+		atomic.StoreInt64(s.stmtTimeout, s.timeout)
+	}
+	s.hooks.rollbackTo(s.mark)
+	return nil
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// SchemaParam is a query param which is replaced with quoted schema name, e.g. `pg:"?SCHEMA.agent"`
+const SchemaParam = "SCHEMA"
+
+var schemaKey = "schema"
+var implicitTxKey = "implicitTx"
+
+// SchemaResolver resolves schema name from context, empty name means default search_path
+type SchemaResolver func(ctx context.Context) string
+
+// NewSchemaContext returns a new Context that carries schema name
+func NewSchemaContext(ctx context.Context, schema string) context.Context {
+	return context.WithValue(ctx, &schemaKey, schema)
+}
+
+// SchemaFromContext returns schema name stored in ctx
+func SchemaFromContext(ctx context.Context) string {
+	schema, _ := ctx.Value(&schemaKey).(string)
+	return schema
+}
+
+// WithSchema binds schema resolved from ctx to the client stored in ctx.
+// Transactions use SET LOCAL search_path, other statements are run in their own transaction with SET LOCAL search_path,
+// ?SCHEMA param is replaced with schema name as well.
+// Statement outside transaction costs four round trips then (BEGIN, SET LOCAL, statement, COMMIT),
+// so statements of a request are better grouped in transaction. Implicit transaction statements other than
+// the statement itself are not logged, traced or counted by metrics.
+func WithSchema(resolver SchemaResolver) Option {
+	return func(ctx context.Context) context.Context {
+		schema := resolver(ctx)
+		if schema == "" {
+			return ctx
+		}
+		dbc := optionClient(ctx)
+		if dbc == nil {
+			return ctx
+		}
+		return withOptionClient(ctx, dbc.WithSchema(schema))
+	}
+}
+
+// withImplicitTx marks ctx of transaction which runs a single statement of schema-bound client
+func withImplicitTx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, &implicitTxKey, true)
+}
+
+// isImplicitTx reports whether query is run by transaction around a single statement of schema-bound client
+func isImplicitTx(ctx context.Context) bool {
+	implicit, _ := ctx.Value(&implicitTxKey).(bool)
+	return implicit
+}
+
+// setLocalSearchPath sets search_path until the end of transaction
+func setLocalSearchPath(tx *pg.Tx, schema string) error {
+	_, err := tx.Exec("SET LOCAL search_path TO ?", pg.Ident(schema))
+	return err
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// ErrShutdown is returned for new operations after shutdown has started
+var ErrShutdown = errors.New("db client is shutting down")
+
+// tracker counts in-flight statements and open transactions of the client.
+// It is installed as query hook, so transaction ends on COMMIT or ROLLBACK even if it is run by Tx() directly.
+type tracker struct {
+	mu      sync.Mutex
+	pending int
+	closing bool
+	idle    chan struct{}
+	// txs are open transactions holding slots with their hooks
+	txs map[*pg.Tx]*txHooks
+}
+
+func newTracker() *tracker {
+	return &tracker{idle: make(chan struct{}), txs: make(map[*pg.Tx]*txHooks)}
+}
+
+func (t *tracker) acquire() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return ErrShutdown
+	}
+	t.pending++
+	return nil
+}
+
+func (t *tracker) release() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending--
+	if t.closing && t.pending == 0 {
+		close(t.idle)
+	}
+}
+
+// begin registers transaction which holds acquired slot until it ends
+func (t *tracker) begin(tx *pg.Tx, hooks *txHooks) {
+	t.mu.Lock()
+	t.txs[tx] = hooks
+	t.mu.Unlock()
+}
+
+// take unregisters transaction, false is returned if transaction has already ended
+func (t *tracker) take(tx *pg.Tx) (*txHooks, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hooks, ok := t.txs[tx]
+	delete(t.txs, tx)
+	return hooks, ok
+}
+
+// end releases slot of transaction taken from tracker and runs its hooks
+func (t *tracker) end(hooks *txHooks, committed bool) {
+	t.release()
+	if committed {
+		hooks.runCommit()
+	} else {
+		hooks.runRollback()
+	}
+}
+
+func (t *tracker) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (t *tracker) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	tx, ok := event.DB.(*pg.Tx)
+	if !ok {
+		return nil
+	}
+	query, _ := event.Query.(string)
+	stmt := strings.ToUpper(strings.TrimSpace(query))
+	if stmt != "COMMIT" && stmt != "ROLLBACK" {
+		return nil
+	}
+	if hooks, ok := t.take(tx); ok {
+		t.end(hooks, stmt == "COMMIT" && event.Err == nil)
+	}
+	return nil
+}
+
+// shutdown stops accepting new operations and waits until pending ones finish or ctx is done,
+// returns number of operations which were still pending
+func (t *tracker) shutdown(ctx context.Context) (int, error) {
+	if t == nil {
+		return 0, nil
+	}
+
+	t.mu.Lock()
+	if !t.closing {
+		t.closing = true
+		if t.pending == 0 {
+			close(t.idle)
+		}
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.idle:
+		return 0, nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.pending, ctx.Err()
+	}
+}
+
+// Shutdown stops accepting new work, waits for in-flight statements and open transactions
+// and closes the client. When ctx is done the client is closed forcibly and the number
+// of operations which were still pending is returned along with ctx error.
+func (w *dbWrapper) Shutdown(ctx context.Context) (int, error) {
+	pending, err := w.tracker.shutdown(ctx)
+	if closeErr := w.Conn.Close(); err == nil {
+		err = closeErr
+	}
+	return pending, err
+}
+
+// acquire registers a new statement, statements within transaction are covered by the transaction itself
+func (w *dbWrapper) acquire() error {
+	if w.Txn != nil {
+		return nil
+	}
+	return w.tracker.acquire()
+}
+
+func (w *dbWrapper) release() {
+	if w.Txn != nil {
+		return
+	}
+	w.tracker.release()
+}
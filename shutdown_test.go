@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_shutdown(t *testing.T) {
+	t.Run("Idle", func(t *testing.T) {
+		tr := newTracker()
+		pending, err := tr.shutdown(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, pending)
+		assert.Equal(t, ErrShutdown, tr.acquire())
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		tr := newTracker()
+		assert.Nil(t, tr.acquire())
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			tr.release()
+		}()
+
+		pending, err := tr.shutdown(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, pending)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		tr := newTracker()
+		assert.Nil(t, tr.acquire())
+		assert.Nil(t, tr.acquire())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		pending, err := tr.shutdown(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 2, pending)
+	})
+}
+
+func TestTracker_txEnd(t *testing.T) {
+	tr := newTracker()
+	ctx := context.Background()
+
+	var calls []string
+	begin := func() *pg.Tx {
+		tx := &pg.Tx{}
+		hooks := &txHooks{}
+		hooks.commit = append(hooks.commit, func() { calls = append(calls, "commit") })
+		hooks.rollback = append(hooks.rollback, func() { calls = append(calls, "rollback") })
+		assert.Nil(t, tr.acquire())
+		tr.begin(tx, hooks)
+		return tx
+	}
+
+	committed, rolledBack, failed := begin(), begin(), begin()
+	assert.Nil(t, tr.AfterQuery(ctx, &pg.QueryEvent{DB: committed, Query: "COMMIT"}))
+	assert.Nil(t, tr.AfterQuery(ctx, &pg.QueryEvent{DB: committed, Query: "COMMIT"}))
+	assert.Nil(t, tr.AfterQuery(ctx, &pg.QueryEvent{DB: rolledBack, Query: "SELECT 1"}))
+	assert.Nil(t, tr.AfterQuery(ctx, &pg.QueryEvent{DB: rolledBack, Query: "ROLLBACK"}))
+	assert.Nil(t, tr.AfterQuery(ctx, &pg.QueryEvent{DB: failed, Query: "COMMIT", Err: pg.ErrTxDone}))
+	assert.Equal(t, []string{"commit", "rollback", "rollback"}, calls)
+
+	pending, err := tr.shutdown(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending)
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+var queryTimeoutKey = "queryTimeout"
+
+// WithQueryTimeout returns a new Context that limits duration of each query run with it,
+// timeout overrides the default client timeout, zero disables timeout
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, &queryTimeoutKey, timeout)
+}
+
+// QueryTimeoutFromContext returns query timeout stored in ctx
+func QueryTimeoutFromContext(ctx context.Context) (time.Duration, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	timeout, ok := ctx.Value(&queryTimeoutKey).(time.Duration)
+	return timeout, ok
+}
+
+// WithDefaultQueryTimeout limits duration of each query of the client stored in ctx.
+// Queries are cancelled by context deadline, transactions use SET LOCAL statement_timeout.
+func WithDefaultQueryTimeout(timeout time.Duration) Option {
+	return func(ctx context.Context) context.Context {
+		dbc := optionClient(ctx)
+		if dbc == nil {
+			return ctx
+		}
+		return withOptionClient(ctx, dbc.WithQueryTimeout(timeout))
+	}
+}
+
+// WithQueryTimeout returns a copy of client with default query timeout
+func (w *dbWrapper) WithQueryTimeout(timeout time.Duration) IClient {
+	cp := *w
+	cp.timeout = timeout
+	return &cp
+}
+
+// QueryTimeout ...
+func (w *dbWrapper) QueryTimeout() time.Duration {
+	return w.timeout
+}
+
+// queryTimeout returns timeout of query run with ctx
+func (w *dbWrapper) queryTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := QueryTimeoutFromContext(ctx); ok {
+		return timeout
+	}
+	return w.timeout
+}
+
+// withTimeout limits query run with ctx, in transaction statement_timeout is changed locally if it differs.
+// Background context is used if ctx is nil, e.g. for ORM queries of client without context.
+func (w *dbWrapper) withTimeout(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := w.queryTimeout(ctx)
+	if w.Txn != nil {
+		if atomic.LoadInt64(w.stmtTimeout) != int64(timeout) {
+			if err := setLocalStatementTimeout(w.Txn, timeout); err != nil {
+				return ctx, func() {}, err
+			}
+			atomic.StoreInt64(w.stmtTimeout, int64(timeout))
+		}
+		return ctx, func() {}, nil
+	}
+
+	if timeout <= 0 {
+		return ctx, func() {}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// setLocalStatementTimeout sets statement_timeout until the end of transaction, zero disables timeout
+func setLocalStatementTimeout(tx *pg.Tx, timeout time.Duration) error {
+	_, err := tx.Exec("SET LOCAL statement_timeout = ?", timeoutMillis(timeout))
+	return err
+}
+
+// timeoutMillis returns timeout in milliseconds rounded up,
+// so that sub-millisecond timeout is not read by Postgres as no limit
+func timeoutMillis(timeout time.Duration) int64 {
+	if timeout <= 0 {
+		return 0
+	}
+	return int64((timeout + time.Millisecond - 1) / time.Millisecond)
+}
+
+type stmtTimeoutHolder interface {
+	statementTimeout() *int64
+}
+
+// stmtTimeoutOf returns statement_timeout cached by client in transaction
+func stmtTimeoutOf(client IClient) *int64 {
+	if holder, ok := client.(stmtTimeoutHolder); ok {
+		return holder.statementTimeout()
+	}
+	return nil
+}
+
+func (w *dbWrapper) statementTimeout() *int64 {
+	return w.stmtTimeout
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	pkgerr "github.com/sanches1984/gopkg-pg-orm/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTimeoutContext(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"})).WithQueryTimeout(time.Second)
+	defer client.Close()
+	w := client.(*dbWrapper)
+
+	ctx, cancel, err := w.withTimeout(context.Background())
+	defer cancel()
+	assert.Nil(t, err)
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	ctx, cancel, err = w.withTimeout(WithQueryTimeout(context.Background(), 0))
+	defer cancel()
+	assert.Nil(t, err)
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+
+	assert.Equal(t, time.Second, w.WrapWithContext(context.Background()).QueryTimeout())
+}
+
+func TestQueryTimeoutNilContext(t *testing.T) {
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"})).WithQueryTimeout(time.Second)
+	defer client.Close()
+
+	ctx, cancel, err := client.(*dbWrapper).withTimeout(nil)
+	defer cancel()
+	assert.Nil(t, err)
+	_, ok := ctx.Deadline()
+	assert.True(t, ok)
+
+	var model operationModel
+	assert.NotPanics(t, func() {
+		err = client.Model(&model).Where("id = 1").Select()
+	})
+	assert.NotNil(t, err)
+}
+
+func TestTimeoutMillis(t *testing.T) {
+	assert.Equal(t, int64(0), timeoutMillis(0))
+	assert.Equal(t, int64(0), timeoutMillis(-time.Second))
+	assert.Equal(t, int64(1), timeoutMillis(time.Microsecond))
+	assert.Equal(t, int64(1), timeoutMillis(time.Millisecond))
+	assert.Equal(t, int64(2), timeoutMillis(1500*time.Microsecond))
+	assert.Equal(t, int64(1000), timeoutMillis(time.Second))
+}
+
+func TestConvertTimeout(t *testing.T) {
+	ctx := context.Background()
+	assert.True(t, pkgerr.IsTimeout(pkgerr.Convert(ctx, context.DeadlineExceeded)))
+	assert.True(t, pkgerr.IsTimeout(pkgerr.Convert(ctx, context.Canceled)))
+	assert.True(t, pkgerr.IsTimeout(pkgerr.Convert(ctx, sqlStateError(pkgerr.CodeQueryCanceled))))
+	assert.True(t, pkgerr.IsInternal(pkgerr.Convert(ctx, sqlStateError("XX000"))))
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/sanches1984/gopkg-pg-orm/redact"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sanches1984/gopkg-pg-orm"
+
+const (
+	querySpan           = "QuerySpan"
+	transactionSpanName = "TRANSACTION"
+	rowsAffectedKey     = attribute.Key("db.rows_affected")
+)
+
+const (
+	// txSpanTTL bounds lifetime of span of transaction which is never committed or rolled back
+	txSpanTTL = time.Hour
+	// txSweepInterval is a minimal interval between sweeps of abandoned transaction spans
+	txSweepInterval = time.Minute
+)
+
+var errTxAbandoned = errors.New("transaction is abandoned")
+
+type tracingHook struct {
+	// lastSweep is unix nano time of the last sweep, it goes first to be 64-bit aligned
+	lastSweep int64
+	tracer    trace.Tracer
+	// txSpans holds *txSpan of open transactions by *pg.Tx
+	txSpans sync.Map
+}
+
+type txSpan struct {
+	span    trace.Span
+	started time.Time
+}
+
+// WithTracing installs query hook which creates OpenTelemetry span per query and
+// a parent span per transaction, global tracer provider is used if tp is nil.
+// Spans carry normalized query, values are never recorded.
+func WithTracing(tp trace.TracerProvider) Option {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(tracerName)
+
+	return func(ctx context.Context) context.Context {
+		dbc := optionClient(ctx)
+		if dbc == nil {
+			return ctx
+		}
+		dbc.Db().AddQueryHook(newTracingHook(tracer))
+		return ctx
+	}
+}
+
+func newTracingHook(tracer trace.Tracer) *tracingHook {
+	return &tracingHook{tracer: tracer}
+}
+
+func (h *tracingHook) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	if isExplain(ctx) || isImplicitTx(ctx) {
+		return ctx, nil
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		return ctx, nil
+	}
+
+	if tx, ok := event.DB.(*pg.Tx); ok {
+		if strings.EqualFold(query, "BEGIN") {
+			h.sweep(time.Now())
+			_, span := h.tracer.Start(ctx, transactionSpanName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemPostgreSQL),
+			)
+			h.txSpans.Store(tx, &txSpan{span: span, started: time.Now()})
+		}
+		if v, ok := h.txSpans.Load(tx); ok {
+			ctx = trace.ContextWithSpan(ctx, v.(*txSpan).span)
+		}
+	}
+
+	operation := operationName(query)
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemPostgreSQL,
+		semconv.DBStatementKey.String(NormalizeQuery(query)),
+		semconv.DBOperationKey.String(operation),
+	}
+	if table := operationTable(event.Model, query); table != "" {
+		attrs = append(attrs, semconv.DBSQLTableKey.String(table))
+	}
+
+	ctx, span := h.tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	if event.Stash == nil {
+		event.Stash = make(map[interface{}]interface{})
+	}
+	event.Stash[querySpan] = span
+	return ctx, nil
+}
+
+func (h *tracingHook) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	span, ok := event.Stash[querySpan].(trace.Span)
+	if !ok {
+		return nil
+	}
+
+	if event.Result != nil {
+		span.SetAttributes(rowsAffectedKey.Int(event.Result.RowsAffected()))
+	}
+	recordError(span, event.Err)
+	span.End()
+
+	if tx, ok := event.DB.(*pg.Tx); ok {
+		query, _ := event.UnformattedQuery()
+		// transaction ends with COMMIT or ROLLBACK, or it is closed if BEGIN fails
+		if strings.EqualFold(query, "COMMIT") || strings.EqualFold(query, "ROLLBACK") ||
+			strings.EqualFold(query, "BEGIN") && event.Err != nil {
+			h.endTx(tx, event.Err)
+		}
+	}
+	return nil
+}
+
+func (h *tracingHook) endTx(tx interface{}, err error) {
+	if v, ok := h.txSpans.Load(tx); ok {
+		h.txSpans.Delete(tx)
+		recordError(v.(*txSpan).span, err)
+		v.(*txSpan).span.End()
+	}
+}
+
+// sweep ends spans of transactions open longer than txSpanTTL, at most once per txSweepInterval
+func (h *tracingHook) sweep(now time.Time) {
+	last := atomic.LoadInt64(&h.lastSweep)
+	if now.UnixNano()-last < int64(txSweepInterval) || !atomic.CompareAndSwapInt64(&h.lastSweep, last, now.UnixNano()) {
+		return
+	}
+
+	h.txSpans.Range(func(tx, v interface{}) bool {
+		if now.Sub(v.(*txSpan).started) >= txSpanTTL {
+			h.endTx(tx, errTxAbandoned)
+		}
+		return true
+	})
+}
+
+func recordError(span trace.Span, err error) {
+	if err == nil || err == pg.ErrNoRows {
+		return
+	}
+	msg := redact.Message(err.Error())
+	span.RecordError(errors.New(msg))
+	span.SetStatus(codes.Error, msg)
+}
+
+// operationName returns the leading keyword of query in upper case
+func operationName(query string) string {
+	return strings.ToUpper(leadingKeyword(query))
+}
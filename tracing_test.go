@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+func TestTracingHook(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	hook := newTracingHook(tp.Tracer(tracerName))
+
+	query := func(db interface{}, query string, err error) {
+		event := &pg.QueryEvent{Query: query, Err: err}
+		if tx, ok := db.(*pg.Tx); ok {
+			event.DB = tx
+		}
+		ctx, _ := hook.BeforeQuery(context.Background(), event)
+		hook.AfterQuery(ctx, event)
+	}
+
+	tx := &pg.Tx{}
+	query(tx, "BEGIN", nil)
+	query(tx, "SELECT * FROM agent WHERE id = ?", nil)
+	query(tx, "COMMIT", errors.New("commit failed"))
+	query(nil, "UPDATE agent SET name = ?", nil)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 5) {
+		return
+	}
+
+	txSpan := spans[3]
+	assert.Equal(t, transactionSpanName, txSpan.Name)
+	assert.Equal(t, codes.Error, txSpan.Status.Code)
+
+	for _, span := range spans[:3] {
+		assert.Equal(t, txSpan.SpanContext.SpanID(), span.Parent.SpanID())
+	}
+
+	sel := spans[1]
+	assert.Equal(t, "SELECT", sel.Name)
+	assert.Contains(t, sel.Attributes, semconv.DBStatementKey.String("select * from agent where id = ?"))
+	assert.Contains(t, sel.Attributes, semconv.DBSQLTableKey.String("agent"))
+
+	upd := spans[4]
+	assert.Equal(t, "UPDATE", upd.Name)
+	assert.False(t, upd.Parent.IsValid())
+}
+
+func TestTracingHookTxSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	hook := newTracingHook(tp.Tracer(tracerName))
+
+	query := func(tx *pg.Tx, query string, err error) {
+		event := &pg.QueryEvent{Query: query, Err: err, DB: tx}
+		ctx, _ := hook.BeforeQuery(context.Background(), event)
+		hook.AfterQuery(ctx, event)
+	}
+
+	failed := &pg.Tx{}
+	query(failed, "BEGIN", errors.New("connection refused"))
+	_, ok := hook.txSpans.Load(failed)
+	assert.False(t, ok)
+
+	abandoned := &pg.Tx{}
+	query(abandoned, "BEGIN", nil)
+	v, ok := hook.txSpans.Load(abandoned)
+	assert.True(t, ok)
+	v.(*txSpan).started = time.Now().Add(-txSpanTTL)
+
+	hook.sweep(time.Now())
+	_, ok = hook.txSpans.Load(abandoned)
+	assert.True(t, ok, "sweep runs once per interval")
+
+	hook.sweep(time.Now().Add(txSweepInterval))
+	_, ok = hook.txSpans.Load(abandoned)
+	assert.False(t, ok)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 4) {
+		assert.Equal(t, codes.Error, spans[3].Status.Code)
+		assert.Equal(t, errTxAbandoned.Error(), spans[3].Status.Description)
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// txHooks holds functions which are called after transaction end
+type txHooks struct {
+	mu       sync.Mutex
+	commit   []func()
+	rollback []func()
+}
+
+type txHooksHolder interface {
+	txHooks() *txHooks
+}
+
+// hooksMark is a position in hooks registry, used to discard hooks registered after savepoint
+type hooksMark struct {
+	commit   int
+	rollback int
+}
+
+// OnCommit registers fn to be called after transaction bound to ctx is committed.
+// Nested transactions attach fn to the outermost transaction, without transaction fn is called immediately.
+func OnCommit(ctx context.Context, fn func()) {
+	OnCommitNamed(ctx, DefaultName, fn)
+}
+
+// OnCommitNamed registers fn to be called after transaction of database registered by name is committed
+func OnCommitNamed(ctx context.Context, name string, fn func()) {
+	hooks := hooksOf(FromContextNamed(ctx, name))
+	if hooks == nil {
+		fn()
+		return
+	}
+
+	hooks.mu.Lock()
+	hooks.commit = append(hooks.commit, fn)
+	hooks.mu.Unlock()
+}
+
+// OnRollback registers fn to be called after transaction bound to ctx is rolled back.
+// Nested transactions attach fn to the outermost transaction, without transaction fn is never called.
+func OnRollback(ctx context.Context, fn func()) {
+	OnRollbackNamed(ctx, DefaultName, fn)
+}
+
+// OnRollbackNamed registers fn to be called after transaction of database registered by name is rolled back
+func OnRollbackNamed(ctx context.Context, name string, fn func()) {
+	hooks := hooksOf(FromContextNamed(ctx, name))
+	if hooks == nil {
+		return
+	}
+
+	hooks.mu.Lock()
+	hooks.rollback = append(hooks.rollback, fn)
+	hooks.mu.Unlock()
+}
+
+func hooksOf(client IClient) *txHooks {
+	if client == nil || client.Tx() == nil {
+		return nil
+	}
+	if holder, ok := client.(txHooksHolder); ok {
+		return holder.txHooks()
+	}
+	return nil
+}
+
+func (h *txHooks) mark() hooksMark {
+	if h == nil {
+		return hooksMark{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return hooksMark{commit: len(h.commit), rollback: len(h.rollback)}
+}
+
+// rollbackTo discards commit hooks registered after mark and runs rollback hooks registered after mark
+func (h *txHooks) rollbackTo(m hooksMark) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	fns := append([]func(){}, h.rollback[m.rollback:]...)
+	h.commit = h.commit[:m.commit]
+	h.rollback = h.rollback[:m.rollback]
+	h.mu.Unlock()
+
+	run(fns)
+}
+
+func (h *txHooks) runCommit() {
+	h.mu.Lock()
+	fns := h.commit
+	h.commit, h.rollback = nil, nil
+	h.mu.Unlock()
+
+	run(fns)
+}
+
+func (h *txHooks) runRollback() {
+	h.mu.Lock()
+	fns := h.rollback
+	h.commit, h.rollback = nil, nil
+	h.mu.Unlock()
+
+	run(fns)
+}
+
+func run(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+// IsolationLevel is a transaction isolation level
+type IsolationLevel string
+
+const (
+	// ReadCommitted is a default PostgreSQL isolation level
+	ReadCommitted IsolationLevel = "READ COMMITTED"
+	// RepeatableRead isolation level
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	// Serializable isolation level
+	Serializable IsolationLevel = "SERIALIZABLE"
+)
+
+// ErrInvalidIsolationLevel is returned for unknown isolation level
+var ErrInvalidIsolationLevel = errors.New("invalid transaction isolation level")
+
+// ErrTxOptionsMismatch is returned when nested transaction requests options which differ from outer transaction
+var ErrTxOptionsMismatch = errors.New("transaction options cannot be changed in nested transaction")
+
+// TxOptions holds transaction characteristics
+type TxOptions struct {
+	Isolation  IsolationLevel
+	ReadOnly   bool
+	Deferrable bool
+}
+
+func (o *TxOptions) isolation() IsolationLevel {
+	if o == nil || o.Isolation == "" {
+		return ReadCommitted
+	}
+	return o.Isolation
+}
+
+func (o *TxOptions) readOnly() bool {
+	return o != nil && o.ReadOnly
+}
+
+// statement returns SET TRANSACTION statement or empty string for default options
+func (o *TxOptions) statement() (string, error) {
+	if o == nil {
+		return "", nil
+	}
+
+	modes := make([]string, 0, 3)
+	switch o.Isolation {
+	case "":
+	case ReadCommitted, RepeatableRead, Serializable:
+		modes = append(modes, "ISOLATION LEVEL "+string(o.Isolation))
+	default:
+		return "", ErrInvalidIsolationLevel
+	}
+	if o.ReadOnly {
+		modes = append(modes, "READ ONLY")
+	}
+	if o.Deferrable {
+		modes = append(modes, "DEFERRABLE")
+	}
+	if len(modes) == 0 {
+		return "", nil
+	}
+
+	return "SET TRANSACTION " + strings.Join(modes, ", "), nil
+}
+
+// CheckNested verifies that nested transaction options can be satisfied by outer transaction
+func (o *TxOptions) CheckNested(outer *TxOptions) error {
+	if o == nil {
+		return nil
+	}
+	if o.isolation() != outer.isolation() || (outer.readOnly() && !o.readOnly()) {
+		return ErrTxOptionsMismatch
+	}
+	return nil
+}
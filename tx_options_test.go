@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxOptions_statement(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *TxOptions
+		want string
+		err  error
+	}{
+		{name: "Nil", opts: nil, want: ""},
+		{name: "Empty", opts: &TxOptions{}, want: ""},
+		{name: "Isolation", opts: &TxOptions{Isolation: RepeatableRead}, want: "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"},
+		{
+			name: "All",
+			opts: &TxOptions{Isolation: Serializable, ReadOnly: true, Deferrable: true},
+			want: "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ ONLY, DEFERRABLE",
+		},
+		{name: "Invalid", opts: &TxOptions{Isolation: "READ UNCOMMITTED; DROP TABLE x"}, err: ErrInvalidIsolationLevel},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.opts.statement()
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestTxOptions_CheckNested(t *testing.T) {
+	assert.Nil(t, (*TxOptions)(nil).CheckNested(&TxOptions{Isolation: Serializable}))
+	assert.Nil(t, (&TxOptions{ReadOnly: true}).CheckNested(nil))
+	assert.Nil(t, (&TxOptions{Isolation: Serializable}).CheckNested(&TxOptions{Isolation: Serializable}))
+	assert.Equal(t, ErrTxOptionsMismatch, (&TxOptions{Isolation: Serializable}).CheckNested(nil))
+	assert.Equal(t, ErrTxOptionsMismatch, (&TxOptions{}).CheckNested(&TxOptions{ReadOnly: true}))
+}
@@ -3,21 +3,37 @@ package database
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/go-pg/pg/v9/orm"
+	"github.com/sanches1984/gopkg-pg-orm/redact"
 )
 
 type dbWrapper struct {
-	ctx              context.Context
-	Conn             *pg.DB
-	Txn              *pg.Tx
-	WrappedProcessor func(ctx context.Context, processor func() (orm.Result, error), query string, model interface{}) (orm.Result, error)
+	ctx          context.Context
+	Conn         *pg.DB
+	Txn          *pg.Tx
+	TxOpts       *TxOptions
+	schema       string
+	hooks        *txHooks
+	tracker      *tracker
+	interceptors []Interceptor
+	timeout      time.Duration
+	comment      *CommentOptions
+	// stmtTimeout is statement_timeout set in transaction
+	stmtTimeout *int64
+	// txCtx is a context of statements in transaction
+	txCtx context.Context
+	// err fails all statements of client, e.g. when search_path is not set in transaction
+	err error
 }
 
 // NewDbClient ...
 func NewDbClient(conn *pg.DB) IClient {
-	return &dbWrapper{Conn: conn}
+	t := newTracker()
+	conn.AddQueryHook(t)
+	return &dbWrapper{Conn: conn, tracker: t}
 }
 
 // Db ...
@@ -30,37 +46,152 @@ func (w *dbWrapper) Tx() *pg.Tx {
 	return w.Txn
 }
 
+// TxOptions ...
+func (w *dbWrapper) TxOptions() *TxOptions {
+	return w.TxOpts
+}
+
 // WrapWithContext ...
 func (w *dbWrapper) WrapWithContext(ctx context.Context) IClient {
 	return &dbWrapper{
-		Conn:             w.Conn.WithContext(ctx),
-		WrappedProcessor: w.WrappedProcessor,
+		Conn:         w.Conn.WithContext(ctx),
+		schema:       w.schema,
+		tracker:      w.tracker,
+		interceptors: w.interceptors,
+		timeout:      w.timeout,
+		comment:      w.comment,
+	}
+}
+
+// WithSchema returns a copy of client bound to schema, search_path is set locally in transaction
+// and each statement outside of transaction is run in its own transaction with local search_path,
+// which costs three extra round trips per statement.
+// If search_path is not set in transaction, all statements of the copy fail with the error.
+func (w *dbWrapper) WithSchema(schema string) IClient {
+	cp := *w
+	cp.schema = schema
+	cp.Conn = w.Conn.WithParam(SchemaParam, pg.Ident(schema))
+	if cp.Txn != nil && cp.err == nil {
+		cp.err = setLocalSearchPath(cp.Txn, schema)
 	}
+	return &cp
+}
+
+// Schema ...
+func (w *dbWrapper) Schema() string {
+	return w.schema
 }
 
 // StartTransaction ...
 func (w *dbWrapper) StartTransaction() (IClient, error) {
-	txn, err := w.Conn.Begin()
+	return w.StartTransactionWithOptions(nil)
+}
+
+// StartTransactionWithOptions ...
+func (w *dbWrapper) StartTransactionWithOptions(opts *TxOptions) (IClient, error) {
+	stmt, err := opts.statement()
 	if err != nil {
 		return nil, err
 	}
 
+	// transaction holds tracker slot until commit or rollback
+	if err := w.tracker.acquire(); err != nil {
+		return nil, err
+	}
+
+	// transaction is bound to detached ctx, so commit and rollback reach the server after ctx is done
+	ctx := w.Context()
+	txn, err := w.Conn.WithContext(detachedContext{ctx}).Begin()
+	if err != nil {
+		w.tracker.release()
+		return nil, err
+	}
+
+	if stmt != "" {
+		if _, err := txn.Exec(stmt); err != nil {
+			txn.Rollback()
+			w.tracker.release()
+			return nil, err
+		}
+	}
+
+	if w.schema != "" {
+		if err := setLocalSearchPath(txn, w.schema); err != nil {
+			txn.Rollback()
+			w.tracker.release()
+			return nil, err
+		}
+	}
+
+	stmtTimeout := int64(w.queryTimeout(ctx))
+	if stmtTimeout > 0 {
+		if err := setLocalStatementTimeout(txn, time.Duration(stmtTimeout)); err != nil {
+			txn.Rollback()
+			w.tracker.release()
+			return nil, err
+		}
+	}
+
+	hooks := &txHooks{}
+	w.tracker.begin(txn, hooks)
 	return &dbWrapper{
-		Conn:             w.Conn,
-		Txn:              txn,
-		WrappedProcessor: w.WrappedProcessor,
+		Conn:         w.Conn,
+		Txn:          txn,
+		TxOpts:       opts,
+		schema:       w.schema,
+		hooks:        hooks,
+		tracker:      w.tracker,
+		interceptors: w.interceptors,
+		timeout:      w.timeout,
+		comment:      w.comment,
+		stmtTimeout:  &stmtTimeout,
+		txCtx:        ctx,
 	}, nil
 }
 
-// SetWrappedQueryProcessor ...
-func (w *dbWrapper) SetWrappedQueryProcessor(processor func(ctx context.Context, processor func() (orm.Result, error), query string, model interface{}) (orm.Result, error)) {
-	w.WrappedProcessor = processor
+// Commit commits transaction and runs after-commit hooks, rollback hooks are run if commit failed.
+// Transaction committed or rolled back by Tx() directly ends the same way.
+func (w *dbWrapper) Commit() error {
+	if w.Txn == nil {
+		return ErrNoTransaction
+	}
+
+	// transaction is taken from query hook, so hooks are run after connection is returned to the pool
+	hooks, ok := w.tracker.take(w.Txn)
+	err := w.Txn.Commit()
+	if ok {
+		w.tracker.end(hooks, err == nil)
+	}
+	return err
+}
+
+// Rollback aborts transaction and runs after-rollback hooks
+func (w *dbWrapper) Rollback() error {
+	if w.Txn == nil {
+		return ErrNoTransaction
+	}
+
+	hooks, ok := w.tracker.take(w.Txn)
+	err := w.Txn.Rollback()
+	if ok {
+		w.tracker.end(hooks, false)
+	}
+	return err
+}
+
+func (w *dbWrapper) txHooks() *txHooks {
+	return w.hooks
+}
+
+// Use appends interceptors to the chain which wraps every client operation
+func (w *dbWrapper) Use(interceptors ...Interceptor) {
+	w.interceptors = append(w.interceptors[:len(w.interceptors):len(w.interceptors)], interceptors...)
 }
 
 // Context ...
 func (w *dbWrapper) Context() context.Context {
 	if w.Txn != nil {
-		return w.Txn.Context()
+		return w.txCtx
 	}
 	return w.Conn.Context()
 }
@@ -83,116 +214,65 @@ func (w *dbWrapper) Model(model ...interface{}) *orm.Query {
 
 // Select ...
 func (w *dbWrapper) Select(model interface{}) error {
-	if w.Txn != nil {
-		return w.Txn.Select(model)
-	}
-	return w.Conn.Select(model)
+	return orm.Select(w, model)
 }
 
 // Insert ...
 func (w *dbWrapper) Insert(model ...interface{}) error {
-	if w.Txn != nil {
-		return w.Txn.Insert(model...)
-	}
-	return w.Conn.Insert(model...)
+	return orm.Insert(w, model...)
 }
 
 // Update ...
 func (w *dbWrapper) Update(model interface{}) error {
-	if w.Txn != nil {
-		return w.Txn.Update(model)
-	}
-	return w.Conn.Update(model)
+	return orm.Update(w, model)
 }
 
 // Delete ...
 func (w *dbWrapper) Delete(model interface{}) error {
-	if w.Txn != nil {
-		return w.Txn.Delete(model)
-	}
-	return w.Conn.Delete(model)
+	return orm.Delete(w, model)
 }
 
 // Exec ...
 func (w *dbWrapper) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
-	processor := func() (orm.Result, error) {
-		if w.Txn != nil {
-			return w.Txn.Exec(query, params...)
-		}
-		return w.Conn.Exec(query, params...)
-	}
-
-	if w.WrappedProcessor == nil {
-		return processor()
-	}
-
-	return w.WrappedProcessor(w.Conn.Context(), processor, w.queryString(query), nil)
+	return w.ExecContext(w.Context(), query, params...)
 }
 
 // ExecOne ...
 func (w *dbWrapper) ExecOne(query interface{}, params ...interface{}) (orm.Result, error) {
-	res, err := w.Exec(query, params...)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := w.assertOneRow(res.RowsAffected()); err != nil {
-		return nil, err
-	}
-	return res, nil
+	return w.ExecOneContext(w.Context(), query, params...)
 }
 
 // Query ...
 func (w *dbWrapper) Query(model, query interface{}, params ...interface{}) (orm.Result, error) {
-	processor := func() (orm.Result, error) {
-		if w.Txn != nil {
-			return w.Txn.Query(model, query, params...)
-		}
-		return w.Conn.Query(model, query, params...)
-	}
-
-	if w.WrappedProcessor == nil {
-		return processor()
-	}
-
-	return w.WrappedProcessor(w.Conn.Context(), processor, w.queryString(query), model)
+	return w.QueryContext(w.Context(), model, query, params...)
 }
 
 // QueryOne ...
 func (w *dbWrapper) QueryOne(model, query interface{}, params ...interface{}) (orm.Result, error) {
-	res, err := w.Query(model, query, params...)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := w.assertOneRow(res.RowsAffected()); err != nil {
-		return nil, err
-	}
-	return res, nil
+	return w.QueryOneContext(w.Context(), model, query, params...)
 }
 
 // CopyFrom ...
 func (w *dbWrapper) CopyFrom(r io.Reader, query interface{}, params ...interface{}) (orm.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.CopyFrom(r, query, params...)
-	}
-	return w.Conn.CopyFrom(r, query, params...)
+	return w.run(w.Context(), OpCopyFrom, nil, query, params, func(c context.Context) (orm.Result, error) {
+		return w.withDB(c, func(db orm.DB) (orm.Result, error) {
+			return db.CopyFrom(r, w.commented(c, query), params...)
+		})
+	})
 }
 
 // CopyTo ...
 func (w *dbWrapper) CopyTo(iw io.Writer, query interface{}, params ...interface{}) (orm.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.CopyTo(iw, query, params...)
-	}
-	return w.Conn.CopyTo(iw, query, params...)
+	return w.run(w.Context(), OpCopyTo, nil, query, params, func(c context.Context) (orm.Result, error) {
+		return w.withDB(c, func(db orm.DB) (orm.Result, error) {
+			return db.CopyTo(iw, w.commented(c, query), params...)
+		})
+	})
 }
 
 // FormatQuery ...
 func (w *dbWrapper) FormatQuery(b []byte, query string, params ...interface{}) []byte {
-	if w.Txn != nil {
-		return w.Txn.Formatter().FormatQuery(b, query, params...)
-	}
-	return w.Conn.Formatter().FormatQuery(b, query, params...)
+	return w.Formatter().FormatQuery(b, query, params...)
 }
 
 func (w *dbWrapper) assertOneRow(affected int) error {
@@ -205,67 +285,172 @@ func (w *dbWrapper) assertOneRow(affected int) error {
 	return nil
 }
 
-func (w *dbWrapper) queryString(query interface{}) string {
+// queryString returns query formatted as go-pg does with values of sensitive columns masked,
+// params of string query are masked before formatting, literals of ORM query are masked after it
+func (w *dbWrapper) queryString(model, query interface{}, params ...interface{}) string {
+	fmter := w.Formatter()
 	switch typed := query.(type) {
+	case string:
+		if n := len(params); n > 0 {
+			if tm, ok := params[n-1].(orm.TableModel); ok {
+				if f, ok := fmter.(*orm.Formatter); ok {
+					fmter = f.WithTableModel(tm)
+					params = params[:n-1]
+				}
+			}
+		}
+		return string(fmter.FormatQuery(nil, typed, redact.Default.MaskParams(typed, model, params)...))
 	case orm.QueryAppender:
-		if b, err := typed.AppendQuery(w.Formatter(), nil); err == nil {
-			return string(b)
+		if f, ok := fmter.(*orm.Formatter); ok {
+			fmter = f.WithModel(typed)
+		}
+		if b, err := typed.AppendQuery(fmter, nil); err == nil {
+			return redact.Default.MaskQuery(string(b), model)
 		}
-	case string:
-		return typed
 	}
-	return ""
+	return queryTemplate(query)
 }
 
-// ForceDelete ...
-func (w *dbWrapper) ForceDelete(values interface{}) error {
+// run runs operation through the interceptor chain with query timeout applied to ctx, kind is detected by query if empty
+func (w *dbWrapper) run(ctx context.Context, kind OpKind, model, query interface{}, params []interface{}, fn func(ctx context.Context) (orm.Result, error)) (orm.Result, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if err := w.acquire(); err != nil {
+		return nil, err
+	}
+	defer w.release()
+
+	ctx, cancel, err := w.withTimeout(ctx)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	if w.schema != "" {
+		// query hooks take schema of the client from ctx
+		ctx = NewSchemaContext(ctx, w.schema)
+	}
+
+	if len(w.interceptors) == 0 {
+		return fn(ctx)
+	}
+
+	queryStr := w.queryString(model, query, params...)
+	if kind == "" {
+		kind = statementKind(queryTemplate(query))
+	}
+	op := &Operation{
+		Kind:  kind,
+		Table: operationTable(model, queryStr),
+		Query: queryStr,
+		Model: model,
+		InTx:  w.Txn != nil,
+	}
+	return chainInterceptors(w.interceptors, ctx, op, func() (orm.Result, error) {
+		return fn(ctx)
+	})
+}
+
+// withDB runs fn on transaction, on transaction with local search_path if client is bound to schema,
+// otherwise on connection pool
+func (w *dbWrapper) withDB(ctx context.Context, fn func(db orm.DB) (orm.Result, error)) (orm.Result, error) {
 	if w.Txn != nil {
-		return w.Txn.ForceDelete(values)
+		return fn(w.Txn)
+	}
+	if w.schema == "" {
+		return fn(w.Conn.WithContext(ctx))
 	}
-	return w.Conn.ForceDelete(values)
+
+	// transaction is bound to detached ctx, so rollback reaches the server after ctx is done,
+	// it is marked, so that query hooks skip its statements other than fn
+	txn, err := w.Conn.WithContext(withImplicitTx(detachedContext{ctx})).Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := setLocalSearchPath(txn, w.schema); err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+
+	res, err := fn(txn)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ForceDelete ...
+func (w *dbWrapper) ForceDelete(values interface{}) error {
+	return orm.ForceDelete(w, values)
 }
 
 // ModelContext ...
 func (w *dbWrapper) ModelContext(c context.Context, model ...interface{}) *orm.Query {
-	if w.Txn != nil {
-		return w.Txn.ModelContext(c, model...)
-	}
-	return w.Conn.ModelContext(c, model...)
+	return orm.NewQuery(w, model...).Context(c)
 }
 
 // ExecContext ...
 func (w *dbWrapper) ExecContext(c context.Context, query interface{}, params ...interface{}) (pg.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.ExecContext(c, query, params...)
-	}
-	return w.Conn.ExecContext(c, query, params...)
+	return w.run(c, "", nil, query, params, func(c context.Context) (orm.Result, error) {
+		return w.withDB(c, func(db orm.DB) (orm.Result, error) {
+			return db.ExecContext(c, w.commented(c, query), params...)
+		})
+	})
 }
 
 // ExecOneContext ...
 func (w *dbWrapper) ExecOneContext(c context.Context, query interface{}, params ...interface{}) (pg.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.ExecOneContext(c, query, params...)
+	res, err := w.ExecContext(c, query, params...)
+	if err != nil {
+		return nil, err
 	}
-	return w.Conn.ExecOneContext(c, query, params...)
+
+	if err := w.assertOneRow(res.RowsAffected()); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 // QueryContext ...
 func (w *dbWrapper) QueryContext(c context.Context, model, query interface{}, params ...interface{}) (pg.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.QueryContext(c, model, query, params...)
-	}
-	return w.Conn.QueryContext(c, model, query, params...)
+	return w.run(c, "", model, query, params, func(c context.Context) (orm.Result, error) {
+		return w.withDB(c, func(db orm.DB) (orm.Result, error) {
+			return db.QueryContext(c, model, w.commented(c, query), params...)
+		})
+	})
 }
 
 // QueryOneContext ...
 func (w *dbWrapper) QueryOneContext(c context.Context, model, query interface{}, params ...interface{}) (pg.Result, error) {
-	if w.Txn != nil {
-		return w.Txn.QueryOneContext(c, model, query, params...)
+	res, err := w.QueryContext(c, model, query, params...)
+	if err != nil {
+		return nil, err
 	}
-	return w.Conn.QueryOneContext(c, model, query, params...)
+
+	if err := w.assertOneRow(res.RowsAffected()); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 // Formatter ...
 func (w *dbWrapper) Formatter() orm.QueryFormatter {
-	return w.Formatter()
+	if w.Txn != nil {
+		return w.Txn.Formatter()
+	}
+	return w.Conn.Formatter()
+}
+
+// detachedContext carries values of ctx, but it is never done
+type detachedContext struct {
+	ctx context.Context
 }
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.ctx.Value(key) }
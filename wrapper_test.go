@@ -0,0 +1,62 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDetachedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(NewRequestIDContext(context.Background(), "req-1"))
+	cancel()
+
+	detached := detachedContext{ctx}
+	assert.Nil(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, "req-1", RequestIDFromContext(detached))
+}
+
+func TestRollbackKeepsCause(t *testing.T) {
+	cause := errors.New("cause")
+	err := rollback(context.Background(), func() error { return errors.New("rollback failed") }, cause)
+	assert.Equal(t, cause, err)
+}
+
+func TestImplicitTxSkippedByHooks(t *testing.T) {
+	ctx := withImplicitTx(detachedContext{context.Background()})
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracing := newTracingHook(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracerName))
+
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+	m, err := NewMetrics(prometheus.NewRegistry(), client, nil)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	logger := newDBLogger(zerolog.New(&buf), NewLoggerOptions().WithLevels(zerolog.InfoLevel, zerolog.InfoLevel, zerolog.ErrorLevel))
+
+	for _, query := range []string{"BEGIN", "SET LOCAL search_path TO tenant", "COMMIT"} {
+		event := &pg.QueryEvent{StartTime: time.Now(), Query: query, DB: &pg.Tx{}}
+		c, _ := tracing.BeforeQuery(ctx, event)
+		tracing.AfterQuery(c, event)
+		m.AfterQuery(c, event)
+		logger.AfterQuery(c, event)
+	}
+
+	assert.Empty(t, exporter.GetSpans())
+	assert.Equal(t, 0, testutil.CollectAndCount(m, "db_query_duration_seconds", "db_transactions_total"))
+	assert.Zero(t, buf.Len())
+}
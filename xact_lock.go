@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// LockXact waits for advisory lock held until the end of transaction bound to ctx,
+// ErrNoTransaction is returned if there is no transaction
+func LockXact(ctx context.Context, key int64) error {
+	return LockXactNamed(ctx, DefaultName, key)
+}
+
+// LockXactNamed waits for advisory lock held until the end of transaction of database registered by name
+func LockXactNamed(ctx context.Context, name string, key int64) error {
+	client, err := txClient(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = ormDB(client).ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", key)
+	return err
+}
+
+// TryLockXact tries to acquire advisory lock held until the end of transaction bound to ctx
+// and returns true in case of success, ErrNoTransaction is returned if there is no transaction
+func TryLockXact(ctx context.Context, key int64) (bool, error) {
+	return TryLockXactNamed(ctx, DefaultName, key)
+}
+
+// TryLockXactNamed tries to acquire advisory lock held until the end of transaction of database registered by name
+func TryLockXactNamed(ctx context.Context, name string, key int64) (bool, error) {
+	client, err := txClient(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	var isLocked bool
+	_, err = ormDB(client).QueryOneContext(ctx, pg.Scan(&isLocked), "SELECT pg_try_advisory_xact_lock(?)", key)
+	return isLocked, err
+}
+
+func txClient(ctx context.Context, name string) (IClient, error) {
+	client := FromContextNamed(ctx, name)
+	if client == nil || client.Tx() == nil {
+		return nil, ErrNoTransaction
+	}
+	return client, nil
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockXactWithoutTransaction(t *testing.T) {
+	assert.Equal(t, ErrNoTransaction, LockXact(context.Background(), 1))
+
+	client := NewDbClient(pg.Connect(&pg.Options{Addr: "localhost:1"}))
+	defer client.Close()
+	ctx := NewContext(context.Background(), client)
+
+	_, err := TryLockXact(ctx, 1)
+	assert.Equal(t, ErrNoTransaction, err)
+}